@@ -0,0 +1,80 @@
+package phash
+
+import (
+	"image"
+	"sort"
+)
+
+// AHash computes a 64-bit average hash (aHash).
+//
+// Pipeline:
+//  1. Grayscale
+//  2. Resize to 8x8
+//  3. Mean of the 64 pixel values
+//  4. Build 64-bit hash: bit=1 if pixel>mean, row-major, MSB-first
+//
+// The layout matches PHash, so HammingDistance works on both.
+func AHash(image image.Image) uint64 {
+	if image == nil {
+		return 0
+	}
+	pix := gray8x8(image)
+	var sum float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			sum += pix[y][x]
+		}
+	}
+	return hashFromPixels(pix, sum/64)
+}
+
+// MedianHash computes a 64-bit median hash.
+// It is AHash with the mean replaced by the median of the 64 pixel values,
+// which makes it less sensitive to a few very bright or very dark areas.
+func MedianHash(image image.Image) uint64 {
+	if image == nil {
+		return 0
+	}
+	pix := gray8x8(image)
+	v := make([]float64, 0, 64)
+	for y := 0; y < 8; y++ {
+		v = append(v, pix[y][:]...)
+	}
+	return hashFromPixels(pix, medianFloat64(v))
+}
+
+// gray8x8 converts the image to grayscale and samples it at 8x8.
+func gray8x8(img image.Image) [8][8]float64 {
+	var out [8][8]float64
+	m := grayMatrix(Resize(Grayscale(img), 8, 8), 8, 8)
+	for y := 0; y < 8; y++ {
+		copy(out[y][:], m[y])
+	}
+	return out
+}
+
+// hashFromPixels builds the 64-bit hash from 8x8 pixel values and a threshold.
+// Bit=1 if pixel>threshold, row-major, MSB-first.
+func hashFromPixels(pix [8][8]float64, threshold float64) uint64 {
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if pix[y][x] > threshold {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// medianFloat64 returns the median of v, averaging the two middle values for even lengths.
+// It sorts v in place.
+func medianFloat64(v []float64) float64 {
+	sort.Float64s(v)
+	n := len(v)
+	if n%2 == 1 {
+		return v[n/2]
+	}
+	return (v[n/2-1] + v[n/2]) / 2
+}
@@ -0,0 +1,56 @@
+package phash
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAHashAndMedianHashGoldenValues(t *testing.T) {
+	testCases := []struct {
+		path   string
+		ahash  uint64
+		median uint64
+	}{
+		{path: "sweater-thumb.jpg", ahash: 0xff9981e3818181ff, median: 0xff9981c3818181ff},
+		{path: "sweater-large.jpg", ahash: 0xff9981e3818181ff, median: 0xff9981c3818181ff},
+		{path: "tblue.jpeg", ahash: 0xffffc381c3c3ffff, median: 0x3c668181c3437e3c},
+		{path: "kblue.webp", ahash: 0xf7e7e7e7c3e3e7ff, median: 0x220226244242263c},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.path, func(t *testing.T) {
+			img := decodeTestImage(t, filepath.Join("test_data", tc.path))
+			if got := AHash(img); got != tc.ahash {
+				t.Fatalf("unexpected aHash: got %016x want %016x", got, tc.ahash)
+			}
+			if got := MedianHash(img); got != tc.median {
+				t.Fatalf("unexpected median hash: got %016x want %016x", got, tc.median)
+			}
+		})
+	}
+}
+
+func BenchmarkAHash(b *testing.B) {
+	img := decodeTestImage(b, filepath.Join("test_data", "sweater-medium.jpg"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		AHash(img)
+	}
+}
+
+func BenchmarkMedianHash(b *testing.B) {
+	img := decodeTestImage(b, filepath.Join("test_data", "sweater-medium.jpg"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MedianHash(img)
+	}
+}
+
+func BenchmarkPHash(b *testing.B) {
+	img := decodeTestImage(b, filepath.Join("test_data", "sweater-medium.jpg"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		PHash(img)
+	}
+}
@@ -0,0 +1,207 @@
+package phash
+
+import (
+	"container/heap"
+	"slices"
+	"sort"
+)
+
+// Match is a single result of an index search.
+type Match struct {
+	ID       string
+	Hash     uint64
+	Distance int
+}
+
+// BKTree is an in-memory Burkhard-Keller tree over 64-bit hashes, keyed by HammingDistance.
+// It answers range queries ("all hashes within d") without comparing against every entry.
+//
+// A BKTree is not safe for concurrent use when Add is involved; guard it with a lock
+// if it is written while being searched.
+type BKTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	hash     uint64
+	ids      []string // several ids may share the exact same hash
+	children []bkChild
+}
+
+type bkChild struct {
+	dist int
+	node *bkNode
+}
+
+// NewBKTree returns an empty BKTree.
+func NewBKTree() *BKTree { return &BKTree{} }
+
+// Len returns the number of (hash, id) pairs in the tree.
+func (t *BKTree) Len() int { return t.size }
+
+// Add inserts a (hash, id) pair. Adding the same pair twice stores it twice.
+func (t *BKTree) Add(hash uint64, id string) {
+	t.size++
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, ids: []string{id}}
+		return
+	}
+	node := t.root
+	for {
+		d := HammingDistance(node.hash, hash)
+		if d == 0 {
+			node.ids = append(node.ids, id)
+			return
+		}
+		next := node.child(d)
+		if next == nil {
+			node.children = append(node.children, bkChild{dist: d, node: &bkNode{hash: hash, ids: []string{id}}})
+			return
+		}
+		node = next
+	}
+}
+
+// Remove deletes every pair with the given id and returns how many were removed.
+// Emptied nodes stay in the tree to route searches to their children.
+func (t *BKTree) Remove(id string) int {
+	count := 0
+	if t.root == nil {
+		return 0
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		before := len(node.ids)
+		node.ids = slices.DeleteFunc(node.ids, func(v string) bool { return v == id })
+		count += before - len(node.ids)
+		for _, c := range node.children {
+			stack = append(stack, c.node)
+		}
+	}
+	t.size -= count
+	return count
+}
+
+// Search returns all entries within maxDist of hash, sorted by distance, then ID.
+func (t *BKTree) Search(hash uint64, maxDist int) []Match {
+	var out []Match
+	if t.root == nil || maxDist < 0 {
+		return out
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := HammingDistance(node.hash, hash)
+		if d <= maxDist {
+			for _, id := range node.ids {
+				out = append(out, Match{ID: id, Hash: node.hash, Distance: d})
+			}
+		}
+		// Triangle inequality: only subtrees at distance in [d-maxDist, d+maxDist] can match.
+		for _, c := range node.children {
+			if c.dist >= d-maxDist && c.dist <= d+maxDist {
+				stack = append(stack, c.node)
+			}
+		}
+	}
+	sortMatches(out)
+	return out
+}
+
+// Nearest returns the k entries closest to hash, sorted by distance, then ID.
+// Ties at the k-th distance are broken by ID.
+func (t *BKTree) Nearest(hash uint64, k int) []Match {
+	if t.root == nil || k <= 0 {
+		return nil
+	}
+	best := &matchHeap{}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := HammingDistance(node.hash, hash)
+		for _, id := range node.ids {
+			m := Match{ID: id, Hash: node.hash, Distance: d}
+			if best.Len() < k {
+				heap.Push(best, m)
+			} else if matchLess(m, (*best)[0]) {
+				(*best)[0] = m
+				heap.Fix(best, 0)
+			}
+		}
+
+		radius := 64
+		if best.Len() == k {
+			radius = (*best)[0].Distance
+		}
+		for _, c := range node.children {
+			if c.dist >= d-radius && c.dist <= d+radius {
+				stack = append(stack, c.node)
+			}
+		}
+	}
+	out := append([]Match(nil), (*best)...)
+	sortMatches(out)
+	return out
+}
+
+// Entries returns every (id, hash) pair in the tree, in tree order.
+func (t *BKTree) Entries() []Entry {
+	out := make([]Entry, 0, t.size)
+	if t.root == nil {
+		return out
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, id := range node.ids {
+			out = append(out, Entry{ID: id, Hash: node.hash})
+		}
+		for i := len(node.children) - 1; i >= 0; i-- {
+			stack = append(stack, node.children[i].node)
+		}
+	}
+	return out
+}
+
+func (n *bkNode) child(d int) *bkNode {
+	for _, c := range n.children {
+		if c.dist == d {
+			return c.node
+		}
+	}
+	return nil
+}
+
+// matchLess orders matches by distance, then ID.
+func matchLess(a, b Match) bool {
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
+	}
+	return a.ID < b.ID
+}
+
+func sortMatches(m []Match) {
+	sort.Slice(m, func(i, j int) bool { return matchLess(m[i], m[j]) })
+}
+
+// matchHeap is a max-heap of matches (worst match on top), used to keep the k best.
+type matchHeap []Match
+
+func (h matchHeap) Len() int           { return len(h) }
+func (h matchHeap) Less(i, j int) bool { return matchLess(h[j], h[i]) }
+func (h matchHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *matchHeap) Push(x any)        { *h = append(*h, x.(Match)) }
+func (h *matchHeap) Pop() any {
+	old := *h
+	m := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return m
+}
@@ -0,0 +1,67 @@
+package phash
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestBKTreeMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tree := NewBKTree()
+	var all []Match
+	base := rng.Uint64()
+	for i := 0; i < 2000; i++ {
+		// Mix random hashes with hashes close to base so small radii have hits.
+		h := rng.Uint64()
+		if i%2 == 0 {
+			h = base ^ (1 << uint(rng.Intn(64))) ^ (1 << uint(rng.Intn(64)))
+		}
+		id := fmt.Sprintf("id-%04d", i)
+		tree.Add(h, id)
+		all = append(all, Match{ID: id, Hash: h})
+	}
+	if tree.Len() != len(all) {
+		t.Fatalf("Len() = %d, want %d", tree.Len(), len(all))
+	}
+
+	for _, query := range []uint64{base, base ^ 0xff, rng.Uint64()} {
+		var brute []Match
+		for _, m := range all {
+			m.Distance = HammingDistance(query, m.Hash)
+			brute = append(brute, m)
+		}
+		sortMatches(brute)
+
+		for _, radius := range []int{0, 3, 10, 20} {
+			var want []Match
+			for _, m := range brute {
+				if m.Distance <= radius {
+					want = append(want, m)
+				}
+			}
+			got := tree.Search(query, radius)
+			if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+				t.Fatalf("Search(%016x, %d): got %d matches want %d", query, radius, len(got), len(want))
+			}
+		}
+
+		for _, k := range []int{1, 5, 50} {
+			got := tree.Nearest(query, k)
+			if !reflect.DeepEqual(got, brute[:k]) {
+				t.Fatalf("Nearest(%016x, %d) = %v, want %v", query, k, got, brute[:k])
+			}
+		}
+	}
+}
+
+func TestBKTreeEmpty(t *testing.T) {
+	tree := NewBKTree()
+	if got := tree.Search(0, 64); len(got) != 0 {
+		t.Fatalf("Search on empty tree = %v", got)
+	}
+	if got := tree.Nearest(0, 3); len(got) != 0 {
+		t.Fatalf("Nearest on empty tree = %v", got)
+	}
+}
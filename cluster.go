@@ -0,0 +1,93 @@
+package phash
+
+import "strconv"
+
+// Cluster is a group of near-duplicate entries.
+type Cluster struct {
+	Representative Entry
+	Members        []Entry // in input order, including the representative
+}
+
+// GroupDuplicates groups entries into connected components of near-duplicates:
+// two entries are linked when their HammingDistance is <= threshold, and links are
+// transitive (union-find), so a cluster may contain members further apart than threshold.
+//
+// Only clusters with at least two members are returned, ordered by the input
+// position of their first member. If better is non-nil, the representative of each
+// cluster is the member for which better(member, others) holds (e.g. the largest
+// image); otherwise it is the first member in input order.
+func GroupDuplicates(entries []Entry, threshold int, better func(a, b Entry) bool) []Cluster {
+	if threshold < 0 || len(entries) < 2 {
+		return nil
+	}
+
+	// Neighbours are found through a MultiIndex keyed by input position.
+	idx := NewMultiIndex(DefaultMultiIndexSubstrings)
+	for i, e := range entries {
+		idx.Add(e.Hash, strconv.Itoa(i))
+	}
+	uf := newUnionFind(len(entries))
+	for i, e := range entries {
+		for _, m := range idx.Search(e.Hash, threshold) {
+			j, _ := strconv.Atoi(m.ID)
+			if j > i {
+				uf.union(i, j)
+			}
+		}
+	}
+
+	byRoot := make(map[int]int) // root -> position in out
+	var out []Cluster
+	for i, e := range entries {
+		root := uf.find(i)
+		if uf.size[root] < 2 {
+			continue
+		}
+		c, ok := byRoot[root]
+		if !ok {
+			c = len(out)
+			byRoot[root] = c
+			out = append(out, Cluster{Representative: e})
+		}
+		out[c].Members = append(out[c].Members, e)
+		if better != nil && better(e, out[c].Representative) {
+			out[c].Representative = e
+		}
+	}
+	return out
+}
+
+// unionFind is a disjoint-set forest with path halving and union by size.
+type unionFind struct {
+	parent []int
+	size   []int
+}
+
+func newUnionFind(n int) *unionFind {
+	uf := &unionFind{parent: make([]int, n), size: make([]int, n)}
+	for i := range uf.parent {
+		uf.parent[i] = i
+		uf.size[i] = 1
+	}
+	return uf
+}
+
+func (uf *unionFind) find(x int) int {
+	for uf.parent[x] != x {
+		uf.parent[x] = uf.parent[uf.parent[x]]
+		x = uf.parent[x]
+	}
+	return x
+}
+
+func (uf *unionFind) union(a, b int) {
+	ra, rb := uf.find(a), uf.find(b)
+	if ra == rb {
+		return
+	}
+	if uf.size[ra] < uf.size[rb] {
+		ra, rb = rb, ra
+	}
+	uf.parent[rb] = ra
+	uf.size[ra] += uf.size[rb]
+}
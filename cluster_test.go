@@ -0,0 +1,36 @@
+package phash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupDuplicates(t *testing.T) {
+	entries := []Entry{
+		{ID: "a", Hash: 0x0000000000000000},
+		{ID: "x", Hash: 0xffffffff00000000},
+		{ID: "b", Hash: 0x0000000000000003}, // 2 from a
+		{ID: "lonely", Hash: 0x00000000ffffffff},
+		{ID: "c", Hash: 0x000000000000000f}, // 2 from b, 4 from a: joins via b
+		{ID: "y", Hash: 0xffffffff00000001},
+	}
+
+	got := GroupDuplicates(entries, 2, nil)
+	want := []Cluster{
+		{Representative: entries[0], Members: []Entry{entries[0], entries[2], entries[4]}},
+		{Representative: entries[1], Members: []Entry{entries[1], entries[5]}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("GroupDuplicates = %+v, want %+v", got, want)
+	}
+
+	// Pick the member with the largest ID as representative.
+	got = GroupDuplicates(entries, 2, func(a, b Entry) bool { return a.ID > b.ID })
+	if got[0].Representative.ID != "c" || got[1].Representative.ID != "y" {
+		t.Fatalf("representatives = %q, %q; want c, y", got[0].Representative.ID, got[1].Representative.ID)
+	}
+
+	if got := GroupDuplicates(entries, 0, nil); len(got) != 0 {
+		t.Fatalf("threshold 0 with distinct hashes: got %+v", got)
+	}
+}
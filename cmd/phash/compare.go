@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+// Exit codes of the compare subcommand.
+const (
+	exitSimilar    = 0 // distance <= threshold
+	exitDifferent  = 1 // distance > threshold
+	exitUsage      = 2
+	exitInputError = 3 // an input could not be read or decoded
+)
+
+// hashed is an input together with its hash; hash is zero when rec.Error is set.
+type hashed struct {
+	rec  record
+	hash phash.Hash
+}
+
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	threshold := fs.Int("threshold", 6, "maximum Hamming distance for the images to count as similar")
+	format := fs.String("format", formatText, "output format: text, json, ndjson or csv")
+	hashFile := fs.String("hashes", "", "file of precomputed `path<TAB>hash` lines (as printed by scan) to compare against; - reads stdin")
+	top := fs.Int("top", 0, "only print the N nearest matches (0 prints all)")
+	matrix := fs.Bool("matrix", false, "print the full NxN distance matrix of all inputs")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "number of files decoded in parallel in directories")
+	inputs, err := parseArgs(fs, args)
+	switch {
+	case err != nil:
+	case len(inputs) < 1 && !(*matrix && *hashFile != ""):
+		err = fmt.Errorf("expected a query input")
+	case !*matrix && len(inputs) < 2 && *hashFile == "":
+		err = fmt.Errorf("expected at least one reference (path, URL, directory or --hashes)")
+	case !*matrix && isDir(inputs[0]):
+		err = fmt.Errorf("the query %s is a directory; pass an image, URL or hash (directories are only allowed as references)", inputs[0])
+	case *threshold < 0:
+		err = fmt.Errorf("--threshold must be >= 0")
+	case *top < 0:
+		err = fmt.Errorf("--top must be >= 0")
+	case *workers < 1:
+		err = fmt.Errorf("--workers must be >= 1")
+	}
+	var out recordWriter
+	if err == nil {
+		out, err = newRecordWriter(*format, os.Stdout, func(record) {}) // text output is printed below
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		fmt.Fprintln(os.Stderr, "usage: phash compare <query> <path-url-dir-or-hash>... [--hashes FILE|-] [--threshold N] [--top N] [--matrix] [--format text|json|ndjson|csv]")
+		return exitUsage
+	}
+
+	var all []hashed
+	for _, in := range inputs {
+		all = append(all, expandInput(in, *workers)...)
+	}
+	if *hashFile != "" {
+		fromFile, err := readHashFile(*hashFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return exitInputError
+		}
+		all = append(all, fromFile...)
+	}
+	switch {
+	case len(all) == 0:
+		fmt.Fprintln(os.Stderr, "error: no images or hashes found in the inputs")
+		return exitInputError
+	case !*matrix && len(all) == 1:
+		fmt.Fprintln(os.Stderr, "error: no references to compare against")
+		return exitInputError
+	}
+
+	failed := false
+	var ok []hashed
+	for _, h := range all {
+		if h.rec.Error != "" {
+			failed = true
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", h.rec.Input, h.rec.Error)
+			continue
+		}
+		ok = append(ok, h)
+	}
+
+	var code int
+	if *matrix {
+		code = writeMatrix(out, *format, ok)
+	} else {
+		// "compare a b" keeps printing the bare distance.
+		distanceOnly := len(inputs) == 2 && *hashFile == "" && len(all) == 2
+		code = writeRanked(out, *format, all, *threshold, *top, distanceOnly)
+	}
+	if err := out.Close(); err != nil {
+		fatal(err)
+	}
+	if failed {
+		return exitInputError
+	}
+	return code
+}
+
+// writeRanked compares all[0] (the query) with every other input and writes the
+// references sorted by distance. Text mode prints "distance<TAB>input" lines, or
+// only the distance when distanceOnly is set. The query itself is not ranked when
+// it also turns up among the references, e.g. inside a reference directory.
+func writeRanked(out recordWriter, format string, all []hashed, threshold, top int, distanceOnly bool) int {
+	query := all[0]
+	if query.rec.Error != "" {
+		return exitInputError
+	}
+	var queryInfo os.FileInfo
+	if query.rec.Input != "-" {
+		queryInfo, _ = os.Stat(query.rec.Input)
+	}
+
+	var refs []record
+	skipped, self := false, 0
+	for _, ref := range all[1:] {
+		if ref.rec.Error != "" {
+			continue
+		}
+		if sameInput(query.rec.Input, queryInfo, ref.rec.Input) {
+			self++
+			continue
+		}
+		r := ref.rec
+		dist, err := query.hash.Distance(ref.hash)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", r.Input, err)
+			skipped = true
+			continue
+		}
+		r.Query = query.rec.Input
+		r.Distance = &dist
+		refs = append(refs, r)
+	}
+	if self > 0 && len(refs) == 0 && !skipped {
+		fmt.Fprintln(os.Stderr, "error: no references to compare against")
+		return exitInputError
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return *refs[i].Distance < *refs[j].Distance })
+
+	code := exitDifferent
+	switch {
+	case skipped:
+		code = exitInputError // a reference could not be compared with the query
+	case len(refs) > 0 && *refs[0].Distance <= threshold:
+		code = exitSimilar
+	}
+	if top > 0 && len(refs) > top {
+		refs = refs[:top]
+	}
+
+	if format == formatText {
+		for _, r := range refs {
+			if distanceOnly {
+				fmt.Printf("%d\n", *r.Distance)
+			} else {
+				fmt.Printf("%d\t%s\n", *r.Distance, r.Input)
+			}
+		}
+		return code
+	}
+	for _, r := range append([]record{query.rec}, refs...) {
+		if err := out.Write(r); err != nil {
+			fatal(err)
+		}
+	}
+	return code
+}
+
+// writeMatrix writes the pairwise distances of all inputs: a tab-separated table in
+// text mode, otherwise one record per (query, input) pair.
+func writeMatrix(out recordWriter, format string, all []hashed) int {
+	dist := make([][]int, len(all))
+	for i := range all {
+		dist[i] = make([]int, len(all))
+		for j := range all {
+			d, err := all[i].hash.Distance(all[j].hash)
+			if err != nil {
+				d = -1
+			}
+			dist[i][j] = d
+		}
+	}
+
+	if format == formatText {
+		for _, h := range all {
+			fmt.Printf("\t%s", h.rec.Input)
+		}
+		fmt.Println()
+		for i, h := range all {
+			fmt.Print(h.rec.Input)
+			for j := range all {
+				if dist[i][j] < 0 {
+					fmt.Print("\t-")
+				} else {
+					fmt.Printf("\t%d", dist[i][j])
+				}
+			}
+			fmt.Println()
+		}
+		return exitSimilar
+	}
+
+	for i, q := range all {
+		for j := range all {
+			r := all[j].rec
+			r.Query = q.rec.Input
+			if dist[i][j] >= 0 {
+				d := dist[i][j]
+				r.Distance = &d
+			} else {
+				r.Error = phash.ErrHashMismatch.Error()
+			}
+			if err := out.Write(r); err != nil {
+				fatal(err)
+			}
+		}
+	}
+	return exitSimilar
+}
+
+// expandInput hashes a path or URL; directories are walked and every image inside is hashed.
+// An argument that is not an existing file but parses as a hash (e.g. "fa85955a872769cb"
+// or "phash:fa85955a872769cb") is used as is, without decoding anything.
+func expandInput(in string, workers int) []hashed {
+	st, statErr := os.Stat(in)
+	if statErr == nil && st.IsDir() {
+		var out []hashed
+		hashTree(in, workers, func(r fileResult) {
+			out = append(out, hashed{rec: r.record(), hash: r.Hash})
+		})
+		sort.Slice(out, func(i, j int) bool { return out[i].rec.Input < out[j].rec.Input })
+		return out
+	}
+	if statErr != nil && in != "-" {
+		if h, err := phash.ParseHash(in); err == nil {
+			return []hashed{{rec: record{Input: in, Hash: h.Hex(), Algorithm: string(h.Algorithm())}, hash: h}}
+		}
+	}
+	rec, h := hashInput(in)
+	return []hashed{{rec: rec, hash: h}}
+}
+
+// sameInput reports whether ref names the query: the same path once cleaned or,
+// when the query is a local file (queryInfo != nil), the same file on disk.
+func sameInput(query string, queryInfo os.FileInfo, ref string) bool {
+	if filepath.Clean(ref) == filepath.Clean(query) {
+		return true
+	}
+	if queryInfo == nil {
+		return false
+	}
+	st, err := os.Stat(ref)
+	return err == nil && os.SameFile(queryInfo, st)
+}
+
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
+
+// readHashFile reads `path<TAB>hash` lines as printed by "phash scan"; "-" reads stdin.
+// Blank lines and lines starting with '#' are skipped.
+func readHashFile(path string) ([]hashed, error) {
+	if path == "-" {
+		if stdinUsed.Swap(true) {
+			return nil, errStdinReused
+		}
+		return parseHashLines(os.Stdin, "stdin")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseHashLines(f, path)
+}
+
+func parseHashLines(r io.Reader, name string) ([]hashed, error) {
+	var out []hashed
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimRight(sc.Text(), "\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(text, '\t')
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected path<TAB>hash", name, line)
+		}
+		h, err := phash.ParseHash(text[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		out = append(out, hashed{
+			rec:  record{Input: text[:i], Hash: h.Hex(), Algorithm: string(h.Algorithm())},
+			hash: h,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+func TestWriteRankedExitCodes(t *testing.T) {
+	query := hashed{rec: record{Input: "query"}, hash: testHash(t, 0)}
+	near := hashed{rec: record{Input: "near"}, hash: testHash(t, 0x3)}
+	far := hashed{rec: record{Input: "far"}, hash: testHash(t, 0xffff)}
+	ahash, err := phash.NewHash(phash.AlgorithmAHash, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := hashed{rec: record{Input: "other-algorithm"}, hash: ahash}
+
+	for _, tc := range []struct {
+		name string
+		refs []hashed
+		want int
+	}{
+		{"similar", []hashed{far, near}, exitSimilar},
+		{"different", []hashed{far}, exitDifferent},
+		{"incomparable only", []hashed{other}, exitInputError},
+		{"incomparable and similar", []hashed{near, other}, exitInputError},
+	} {
+		out, err := newRecordWriter(formatNDJSON, io.Discard, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := writeRanked(out, formatNDJSON, append([]hashed{query}, tc.refs...), 4, 0, false); got != tc.want {
+			t.Fatalf("%s: exit code %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRunCompareWithoutReferences(t *testing.T) {
+	empty1, empty2 := t.TempDir(), t.TempDir()
+	emptyHashes := filepath.Join(t.TempDir(), "hashes.tsv")
+	if err := os.WriteFile(emptyHashes, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"--matrix", empty1, empty2},
+		{"--matrix", "--hashes", emptyHashes},
+		{"../../test_data/tblue.jpeg", empty1},
+		{"../../test_data/tblue.jpeg", "--hashes", emptyHashes},
+	} {
+		if got := runCompare(args); got != exitInputError {
+			t.Fatalf("compare %q = %d, want %d", args, got, exitInputError)
+		}
+	}
+}
+
+func TestRunCompareRejectsDirectoryQuery(t *testing.T) {
+	if got := runCompare([]string{"../../test_data", "../../test_data/tblue.jpeg"}); got != exitUsage {
+		t.Fatalf("directory query: exit %d, want %d", got, exitUsage)
+	}
+}
+
+func TestWriteRankedSkipsQuery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "query.jpg")
+	if err := os.WriteFile(path, []byte("not decoded"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.jpg")
+	if err := os.Link(path, link); err != nil {
+		t.Fatal(err)
+	}
+	query := hashed{rec: record{Input: path}, hash: testHash(t, 0)}
+	self := []hashed{
+		{rec: record{Input: dir + "/./query.jpg"}, hash: testHash(t, 0)},
+		{rec: record{Input: link}, hash: testHash(t, 0)},
+	}
+	far := hashed{rec: record{Input: "far"}, hash: testHash(t, 0xffff)}
+
+	for _, tc := range []struct {
+		name string
+		refs []hashed
+		want int
+	}{
+		{"query among references", append(self, far), exitDifferent},
+		{"only the query", self, exitInputError},
+	} {
+		out, err := newRecordWriter(formatNDJSON, io.Discard, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := writeRanked(out, formatNDJSON, append([]hashed{query}, tc.refs...), 4, 0, false); got != tc.want {
+			t.Fatalf("%s: exit code %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
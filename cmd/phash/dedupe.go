@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+// Dedupe actions applied to every non-representative member of a group.
+const (
+	actionReport   = "report"
+	actionHardlink = "hardlink"
+	actionMove     = "move"
+	actionDelete   = "delete"
+)
+
+func runDedupe(args []string) int {
+	fs := flag.NewFlagSet("dedupe", flag.ContinueOnError)
+	threshold := fs.Int("threshold", 6, "maximum Hamming distance between near-duplicates")
+	action := fs.String("action", actionReport, "report, hardlink, move or delete")
+	dest := fs.String("dest", "", "destination folder for --action move")
+	dryRun := fs.Bool("dry-run", true, "only print what --action would do")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "number of files decoded in parallel")
+	hashFile := fs.String("hashes", "", "file of precomputed `path<TAB>hash` lines (as printed by scan); - reads stdin")
+	rest, err := parseArgs(fs, args)
+	if err == nil && (len(rest) > 1 || (len(rest) == 0 && *hashFile == "")) {
+		err = fmt.Errorf("expected one directory and/or --hashes")
+	}
+	if err == nil && *threshold < 0 {
+		err = fmt.Errorf("--threshold must be >= 0")
+	}
+	if err == nil && *workers < 1 {
+		err = fmt.Errorf("--workers must be >= 1")
+	}
+	if err == nil {
+		switch *action {
+		case actionReport, actionHardlink, actionDelete:
+		case actionMove:
+			if *dest == "" {
+				err = fmt.Errorf("--action move requires --dest")
+			}
+		default:
+			err = fmt.Errorf("unknown --action %q", *action)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		fmt.Fprintln(os.Stderr, "usage: phash dedupe [<dir>] [--hashes FILE|-] [--threshold N] [--action report|hardlink|move|delete] [--dest DIR] [--dry-run=false]")
+		return 2
+	}
+
+	failed := 0
+	var root string
+	var files []fileResult
+	if len(rest) == 1 {
+		root = rest[0]
+		hashTree(root, *workers, func(r fileResult) {
+			if r.Err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "error: %s: %v\n", r.Path, r.Err)
+				return
+			}
+			files = append(files, r)
+		})
+	}
+	if *hashFile != "" {
+		fromFile, err := readHashFile(*hashFile)
+		if err != nil {
+			// Nothing was grouped, so this is not the "some files failed" exit 1.
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return exitInputError
+		}
+		for _, h := range fromFile {
+			if h.hash.Algorithm() != phash.AlgorithmPHash || h.hash.Bits() != 64 {
+				failed++
+				fmt.Fprintf(os.Stderr, "error: %s: %v: want a 64-bit phash\n", h.rec.Input, phash.ErrHashMismatch)
+				continue
+			}
+			files = append(files, fileResult{Path: h.rec.Input, Hash: h.hash})
+		}
+	}
+
+	groups := dedupeGroups(files, *threshold)
+	for i, g := range groups {
+		printGroup(i+1, g)
+		if *action != actionReport {
+			failed += applyGroup(g, *action, root, *dest, *threshold, *dryRun)
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// dedupeGroups clusters files by pHash and returns each group with its
+// representative (the file to keep) first, followed by the duplicates in path order.
+// The representative is the largest image by pixel count, then by file size.
+func dedupeGroups(files []fileResult, threshold int) [][]fileResult {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	byPath := make(map[string]fileResult, len(files))
+	entries := make([]phash.Entry, len(files))
+	for i, f := range files {
+		byPath[f.Path] = f
+		entries[i] = phash.Entry{ID: f.Path, Hash: f.Hash.Uint64()}
+	}
+
+	larger := func(a, b phash.Entry) bool {
+		fa, fb := byPath[a.ID], byPath[b.ID]
+		if pa, pb := fa.Width*fa.Height, fb.Width*fb.Height; pa != pb {
+			return pa > pb
+		}
+		return fa.Size > fb.Size
+	}
+
+	var groups [][]fileResult
+	for _, c := range phash.GroupDuplicates(entries, threshold, larger) {
+		g := []fileResult{byPath[c.Representative.ID]}
+		for _, m := range c.Members {
+			if m.ID != c.Representative.ID {
+				g = append(g, byPath[m.ID])
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// printGroup prints a group as tab-separated lines: the file to keep, the
+// duplicates, then the pairwise Hamming distances between all members.
+func printGroup(n int, g []fileResult) {
+	fmt.Printf("# group %d (%d files)\n", n, len(g))
+	for i, f := range g {
+		role := "dup"
+		if i == 0 {
+			role = "keep"
+		}
+		size := "-" // unknown for entries read from a hash file
+		if f.Width > 0 {
+			size = fmt.Sprintf("%dx%d", f.Width, f.Height)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", role, f.Path, size, f.Hash.Hex())
+	}
+	for i := range g {
+		for j := i + 1; j < len(g); j++ {
+			d, _ := g[i].Hash.Distance(g[j].Hash)
+			fmt.Printf("dist\t%s\t%s\t%d\n", g[i].Path, g[j].Path, d)
+		}
+	}
+}
+
+// applyGroup applies action to the duplicates in g and returns the number of failures.
+// Groups are connected components, so a member can be linked to the kept file only
+// through other members; members more than threshold bits from g[0] are reported
+// with a "skip" line and left alone.
+func applyGroup(g []fileResult, action, root, dest string, threshold int, dryRun bool) int {
+	failed := 0
+	for _, dup := range g[1:] {
+		d, err := g[0].Hash.Distance(dup.Hash)
+		if err != nil {
+			// The distance is unknown, not 0: print "-" and say why.
+			fmt.Printf("skip\t%s\t%s\t-\n", dup.Path, g[0].Path)
+			failed++
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", dup.Path, err)
+			continue
+		}
+		if d > threshold {
+			fmt.Printf("skip\t%s\t%s\t%d\n", dup.Path, g[0].Path, d)
+			continue
+		}
+		if err := applyAction(action, root, dest, g[0].Path, dup.Path, dryRun); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", dup.Path, err)
+		}
+	}
+	return failed
+}
+
+// applyAction handles one duplicate. With dryRun it only prints what it would do.
+func applyAction(action, root, dest, keep, dup string, dryRun bool) error {
+	var target string
+	switch action {
+	case actionHardlink:
+		target = keep
+	case actionMove:
+		rel, err := movedPath(root, dup)
+		if err != nil {
+			return err
+		}
+		target = filepath.Join(dest, rel)
+		// os.Rename silently replaces an existing file.
+		if _, err := os.Lstat(target); err == nil {
+			return fmt.Errorf("%s already exists", target)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if dryRun {
+		if target != "" {
+			fmt.Printf("would %s\t%s\t%s\n", action, dup, target)
+		} else {
+			fmt.Printf("would %s\t%s\n", action, dup)
+		}
+		return nil
+	}
+
+	var err error
+	switch action {
+	case actionHardlink:
+		err = replaceWithHardlink(keep, dup)
+	case actionMove:
+		if err = os.MkdirAll(filepath.Dir(target), 0o755); err == nil {
+			err = os.Rename(dup, target)
+		}
+	case actionDelete:
+		err = os.Remove(dup)
+	}
+	if err != nil {
+		return err
+	}
+	if target != "" {
+		fmt.Printf("%s\t%s\t%s\n", action, dup, target)
+	} else {
+		fmt.Printf("%s\t%s\n", action, dup)
+	}
+	return nil
+}
+
+// movedPath returns the path of dup below the move destination: relative to root
+// when dup was found by walking root, otherwise its own path without the volume.
+// Paths that would escape the destination (e.g. "../x.jpg" from a hash file) are rejected.
+func movedPath(root, dup string) (string, error) {
+	if root != "" {
+		if rel, err := filepath.Rel(root, dup); err == nil && filepath.IsLocal(rel) {
+			return rel, nil
+		}
+	}
+	p := filepath.Clean(dup)
+	p = strings.TrimPrefix(p, filepath.VolumeName(p))
+	p = strings.TrimLeft(p, `/\`)
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("cannot move %q below --dest", dup)
+	}
+	return p, nil
+}
+
+// replaceWithHardlink atomically replaces dup with a hard link to keep.
+func replaceWithHardlink(keep, dup string) error {
+	ki, err := os.Stat(keep)
+	if err != nil {
+		return err
+	}
+	di, err := os.Stat(dup)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(ki, di) {
+		return nil
+	}
+	tmp := dup + ".phash-link"
+	if err := os.Link(keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dup); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
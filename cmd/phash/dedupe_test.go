@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+// testHash returns a 64-bit pHash for h.
+func testHash(t *testing.T, h uint64) phash.Hash {
+	t.Helper()
+	out, err := phash.NewHash(phash.AlgorithmPHash, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDedupeDeleteSkipsChainedMembers(t *testing.T) {
+	dir := t.TempDir()
+	files := []fileResult{
+		{Path: filepath.Join(dir, "keep.jpg"), Hash: testHash(t, 0), Width: 200, Height: 200},
+		{Path: filepath.Join(dir, "near.jpg"), Hash: testHash(t, 0x0f), Width: 100, Height: 100}, // 4 bits from keep
+		{Path: filepath.Join(dir, "far.jpg"), Hash: testHash(t, 0xff), Width: 100, Height: 100},  // 4 from near, 8 from keep
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.Path, []byte(f.Path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups := dedupeGroups(files, 5)
+	if len(groups) != 1 || len(groups[0]) != 3 || filepath.Base(groups[0][0].Path) != "keep.jpg" {
+		t.Fatalf("groups = %v, want one chain of three kept by keep.jpg", groups)
+	}
+	if failed := applyGroup(groups[0], actionDelete, dir, "", 5, false); failed != 0 {
+		t.Fatalf("applyGroup reported %d failures", failed)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		exists bool
+	}{{"keep.jpg", true}, {"near.jpg", false}, {"far.jpg", true}} {
+		_, err := os.Stat(filepath.Join(dir, tc.name))
+		if exists := err == nil; exists != tc.exists {
+			t.Fatalf("%s exists = %v, want %v", tc.name, exists, tc.exists)
+		}
+	}
+}
+
+func TestDedupeSkipsIncomparableMembers(t *testing.T) {
+	dir := t.TempDir()
+	ahash, err := phash.NewHash(phash.AlgorithmAHash, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := []fileResult{
+		{Path: filepath.Join(dir, "keep.jpg"), Hash: testHash(t, 0)},
+		{Path: filepath.Join(dir, "other.jpg"), Hash: ahash},
+	}
+	for _, f := range group {
+		if err := os.WriteFile(f.Path, []byte(f.Path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if failed := applyGroup(group, actionDelete, dir, "", 5, false); failed != 1 {
+		t.Fatalf("applyGroup reported %d failures, want 1", failed)
+	}
+	if _, err := os.Stat(group[1].Path); err != nil {
+		t.Fatalf("incomparable member was deleted: %v", err)
+	}
+}
+
+func TestRunDedupeUnreadableHashFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.tsv")
+	if got := runDedupe([]string{"--hashes", missing}); got != exitInputError {
+		t.Fatalf("dedupe --hashes %s = %d, want %d", missing, got, exitInputError)
+	}
+}
+
+func TestMovedPath(t *testing.T) {
+	for _, tc := range []struct {
+		root, dup, want string
+		ok              bool
+	}{
+		{"photos", filepath.Join("photos", "a", "b.jpg"), filepath.Join("a", "b.jpg"), true},
+		{"", "/srv/img/b.jpg", filepath.Join("srv", "img", "b.jpg"), true},
+		{"", "../evil.jpg", "", false},
+		{"", "a/../../evil.jpg", "", false},
+		{"photos", "../evil.jpg", "", false},
+	} {
+		got, err := movedPath(tc.root, tc.dup)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Fatalf("movedPath(%q, %q) = %q, %v; want %q, ok=%v", tc.root, tc.dup, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestMoveKeepsExistingTarget(t *testing.T) {
+	dir := t.TempDir()
+	root, dest := filepath.Join(dir, "photos"), filepath.Join(dir, "dups")
+	for _, p := range []string{filepath.Join(root, "a.jpg"), filepath.Join(root, "b.jpg"), filepath.Join(dest, "b.jpg")} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := applyAction(actionMove, root, dest, filepath.Join(root, "a.jpg"), filepath.Join(root, "b.jpg"), false)
+	if err == nil {
+		t.Fatal("move over an existing file succeeded")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dest, "b.jpg")); string(b) != filepath.Join(dest, "b.jpg") {
+		t.Fatalf("existing target was overwritten with %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b.jpg")); err != nil {
+		t.Fatalf("source was moved: %v", err)
+	}
+}
@@ -2,60 +2,166 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	phash "github.com/enot-style/go-phash"
 )
 
 func main() {
 	args := os.Args[1:]
-	if len(args) < 1 || len(args) > 2 {
+	if len(args) > 0 {
+		switch args[0] {
+		case "scan":
+			os.Exit(runScan(args[1:]))
+		case "dedupe":
+			os.Exit(runDedupe(args[1:]))
+		case "compare":
+			os.Exit(runCompare(args[1:]))
+		case "serve":
+			os.Exit(runServe(args[1:]))
+		}
+	}
+
+	os.Exit(runHash(args))
+}
+
+// runHash hashes one input, or compares two inputs by Hamming distance.
+func runHash(args []string) int {
+	fs := flag.NewFlagSet("phash", flag.ContinueOnError)
+	format := fs.String("format", formatText, "output format: text, json, ndjson or csv")
+	inputs, err := parseArgs(fs, args)
+	if err != nil || len(inputs) < 1 || len(inputs) > 2 {
 		usage()
-		os.Exit(2)
+		return 2
 	}
 
-	img1, err := loadImage(args[0])
+	out, err := newRecordWriter(*format, os.Stdout, func(r record) {
+		switch {
+		case r.Error != "":
+			fmt.Fprintln(os.Stderr, "error:", r.Error)
+		case r.Distance != nil:
+			fmt.Printf("%d\n", *r.Distance)
+		case len(inputs) == 1:
+			fmt.Println(r.Hash)
+		}
+	})
 	if err != nil {
-		fatal(err)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
 	}
-	hash1 := phash.PHash(img1)
 
-	if len(args) == 1 {
-		fmt.Printf("%016x\n", hash1)
-		return
+	recs := make([]record, len(inputs))
+	hashes := make([]phash.Hash, len(inputs))
+	failed := false
+	for i, in := range inputs {
+		recs[i], hashes[i] = hashInput(in)
+		failed = failed || recs[i].Error != ""
+	}
+	if len(inputs) == 2 && !failed {
+		dist, err := hashes[0].Distance(hashes[1])
+		if err != nil {
+			recs[1].Error = err.Error()
+			failed = true
+		} else {
+			recs[1].Query = inputs[0]
+			recs[1].Distance = &dist
+		}
 	}
 
-	img2, err := loadImage(args[1])
-	if err != nil {
+	for _, r := range recs {
+		if err := out.Write(r); err != nil {
+			fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
 		fatal(err)
 	}
-	hash2 := phash.PHash(img2)
-	dist := phash.HammingDistance(hash1, hash2)
+	if failed {
+		return 1
+	}
+	return 0
+}
 
-	fmt.Printf("%d\n", dist)
+// hashInput loads and hashes one path or URL. Failures are reported in the record's Error.
+func hashInput(in string) (record, phash.Hash) {
+	r := record{Input: in}
+	img, format, err := loadImage(in)
+	if err != nil {
+		r.Error = err.Error()
+		return r, phash.Hash{}
+	}
+	h, err := phash.HashImage(img, phash.AlgorithmPHash)
+	if err != nil {
+		r.Error = err.Error()
+		return r, phash.Hash{}
+	}
+	r.Format = format
+	r.Width, r.Height = imageSize(img)
+	r.Hash = h.Hex()
+	r.Algorithm = string(h.Algorithm())
+	return r, h
 }
 
-func loadImage(arg string) (image.Image, error) {
+// stdinUsed records that "-" has been read, since stdin can only be consumed once.
+var stdinUsed atomic.Bool
+
+// loadImage decodes a local path, an http(s) URL, or "-" for stdin.
+func loadImage(arg string) (image.Image, string, error) {
 	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
-		img, _, err := phash.DownloadAndDecodeAny(context.Background(), arg)
-		return img, err
+		return phash.DownloadAndDecodeAny(context.Background(), arg)
+	}
+	if arg == "-" {
+		if stdinUsed.Swap(true) {
+			return nil, "", errStdinReused
+		}
+		return phash.DecodeAny(os.Stdin)
 	}
 
 	f, err := os.Open(arg)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer f.Close()
 
-	img, _, err := phash.DecodeAny(f)
-	return img, err
+	return phash.DecodeAny(f)
 }
 
+// parseArgs parses fs from args, allowing flags after positional arguments
+// (e.g. "scan <dir> --workers 4"), and returns the positional arguments.
+// A literal "--" ends flag parsing.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(os.Stderr)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+var errStdinReused = errors.New(`stdin ("-") can only be used once`)
+
 func usage() {
-	fmt.Fprintln(os.Stderr, "usage: phash <path-or-url> [path-or-url]")
+	fmt.Fprintln(os.Stderr, "usage: phash [--format text|json|ndjson|csv] <path|url|-> [path|url|-]")
+	fmt.Fprintln(os.Stderr, "       phash compare <query> <path-url-dir-or-hash>... [--hashes FILE|-] [--threshold N] [--top N] [--matrix] [--format F]")
+	fmt.Fprintln(os.Stderr, "       phash scan [--workers N] [--format text|json|ndjson|csv] <dir>")
+	fmt.Fprintln(os.Stderr, "      ", serveUsage)
+	fmt.Fprintln(os.Stderr, "       phash dedupe [<dir>] [--hashes FILE|-] [--threshold N] [--action report|hardlink|move|delete] [--dest DIR] [--dry-run=false]")
 }
 
 func fatal(err error) {
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Output formats accepted by --format.
+const (
+	formatText   = "text"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// record is one result line in the structured output formats.
+type record struct {
+	Input     string `json:"input"`
+	Format    string `json:"format,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Query     string `json:"query,omitempty"`    // input this record was compared against
+	Distance  *int   `json:"distance,omitempty"` // Hamming distance to Query
+	Error     string `json:"error,omitempty"`
+}
+
+var csvHeader = []string{"input", "format", "width", "height", "hash", "algorithm", "query", "distance", "error"}
+
+// recordWriter writes records in one output format.
+// Close must be called to finish the output (e.g. the closing bracket of a JSON array).
+type recordWriter interface {
+	Write(r record) error
+	Close() error
+}
+
+// newRecordWriter returns a writer for format. In text mode every record is
+// passed to text, which prints it in the command's plain-text form.
+func newRecordWriter(format string, w io.Writer, text func(record)) (recordWriter, error) {
+	switch format {
+	case formatText:
+		return textWriter(text), nil
+	case formatJSON:
+		return &jsonWriter{w: w}, nil
+	case formatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case formatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown --format %q (want text, json, ndjson or csv)", format)
+	}
+}
+
+type textWriter func(record)
+
+func (t textWriter) Write(r record) error { t(r); return nil }
+func (t textWriter) Close() error         { return nil }
+
+// jsonWriter writes a single JSON array, one element per line.
+type jsonWriter struct {
+	w io.Writer
+	n int
+}
+
+func (j *jsonWriter) Write(r record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.n == 0 {
+		sep = "[\n"
+	}
+	j.n++
+	_, err = fmt.Fprintf(j.w, "%s  %s", sep, b)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if j.n == 0 {
+		_, err := io.WriteString(j.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+type ndjsonWriter struct{ enc *json.Encoder }
+
+func (n *ndjsonWriter) Write(r record) error { return n.enc.Encode(r) }
+func (n *ndjsonWriter) Close() error         { return nil }
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) Write(r record) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	itoa := func(v int) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.Itoa(v)
+	}
+	dist := ""
+	if r.Distance != nil {
+		dist = strconv.Itoa(*r.Distance)
+	}
+	return c.w.Write([]string{r.Input, r.Format, itoa(r.Width), itoa(r.Height), r.Hash, r.Algorithm, r.Query, dist, r.Error})
+}
+
+func (c *csvWriter) Close() error {
+	if !c.header {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+// imageExts lists the file extensions picked up when walking a directory.
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".bmp":  true,
+	".webp": true,
+	".tif":  true,
+	".tiff": true,
+}
+
+// fileResult is the outcome of hashing one file found while walking a directory.
+type fileResult struct {
+	Path   string
+	Hash   phash.Hash
+	Format string
+	Width  int
+	Height int
+	Size   int64
+	Err    error
+}
+
+func runScan(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "number of files decoded in parallel")
+	format := fs.String("format", formatText, "output format: text, json, ndjson or csv")
+	rest, err := parseArgs(fs, args)
+	if err != nil || len(rest) != 1 || *workers < 1 {
+		fmt.Fprintln(os.Stderr, "usage: phash scan [--workers N] [--format text|json|ndjson|csv] <dir>")
+		return 2
+	}
+	out, err := newRecordWriter(*format, os.Stdout, func(r record) {
+		if r.Error != "" {
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", r.Input, r.Error)
+			return
+		}
+		fmt.Printf("%s\t%s\n", r.Input, r.Hash)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+
+	start := time.Now()
+	var files, failed int
+	var bytes int64
+	var writeErr error
+	hashTree(rest[0], *workers, func(r fileResult) {
+		files++
+		if r.Err != nil {
+			failed++
+		} else {
+			bytes += r.Size
+		}
+		if writeErr == nil {
+			writeErr = out.Write(r.record())
+		}
+	})
+	if writeErr == nil {
+		writeErr = out.Close()
+	}
+	if writeErr != nil {
+		fatal(writeErr)
+	}
+	reportThroughput(files, failed, bytes, time.Since(start))
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// hashTree walks root and hashes every image file on a pool of workers.
+// fn is called from a single goroutine, in completion order.
+// Files that cannot be read or decoded are reported with Err set; the walk continues.
+func hashTree(root string, workers int, fn func(fileResult)) {
+	paths := make(chan string)
+	results := make(chan fileResult)
+
+	go func() {
+		defer close(paths)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				results <- fileResult{Path: path, Err: err}
+				return nil
+			}
+			if !d.IsDir() && imageExts[strings.ToLower(filepath.Ext(path))] {
+				paths <- path
+			}
+			return nil
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				results <- hashFile(path)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		fn(r)
+	}
+}
+
+// record converts r to an output record.
+func (r fileResult) record() record {
+	rec := record{Input: r.Path}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+		return rec
+	}
+	rec.Format = r.Format
+	rec.Width, rec.Height = r.Width, r.Height
+	rec.Hash = r.Hash.Hex()
+	rec.Algorithm = string(r.Hash.Algorithm())
+	return rec
+}
+
+func hashFile(path string) fileResult {
+	r := fileResult{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil {
+		r.Size = st.Size()
+	}
+
+	img, format, err := phash.DecodeAny(f)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Format = format
+	r.Width, r.Height = imageSize(img)
+	r.Hash, r.Err = phash.HashImage(img, phash.AlgorithmPHash)
+	return r
+}
+
+func imageSize(img image.Image) (int, int) {
+	b := img.Bounds()
+	return b.Dx(), b.Dy()
+}
+
+func reportThroughput(files, failed int, bytes int64, elapsed time.Duration) {
+	secs := elapsed.Seconds()
+	if secs <= 0 {
+		secs = 1e-9
+	}
+	fmt.Fprintf(os.Stderr, "scanned %d files (%d errors) in %s: %.1f files/s, %.1f MB/s\n",
+		files, failed, elapsed.Round(time.Millisecond), float64(files)/secs, float64(bytes)/secs/1e6)
+}
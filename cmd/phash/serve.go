@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+// Timeouts of the HTTP server. Writes are timed from the end of the request
+// headers, so the write timeout also covers reading the body and fetching by URL.
+const (
+	serveReadTimeout  = time.Minute
+	serveWriteTimeout = 2 * time.Minute
+)
+
+// serveUsage is the synopsis printed by "phash serve" and the top-level usage.
+const serveUsage = "phash serve [--addr ADDR] [--max-bytes N] [--max-pixels N] [--max-concurrency N] [--allow-fetch] [--fetch-timeout D] [--index-file PATH]"
+
+// server serves the HTTP API. Decoding and hashing are bounded by sem.
+type server struct {
+	maxBytes     int64
+	limits       phash.DecodeLimits
+	allowFetch   bool
+	client       *http.Client // for fetching by URL; nil uses http.DefaultClient
+	fetchTimeout time.Duration
+	sem          chan struct{}
+	index        *hashIndex
+}
+
+// imageResult is the JSON description of one hashed image.
+type imageResult struct {
+	Hash      string `json:"hash"`
+	Algorithm string `json:"algorithm"`
+	Format    string `json:"format,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+
+	hash phash.Hash
+}
+
+// compareResult is the JSON response of POST /compare.
+type compareResult struct {
+	A        imageResult `json:"a"`
+	B        imageResult `json:"b"`
+	Distance int         `json:"distance"`
+}
+
+// httpError is an error with the HTTP status it is reported with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e httpError) Error() string { return e.err.Error() }
+func (e httpError) Unwrap() error { return e.err }
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "listen address")
+	maxBytes := fs.Int64("max-bytes", 32<<20, "maximum size of an uploaded or downloaded image in bytes")
+	maxPixels := fs.Int64("max-pixels", 100_000_000, "maximum width*height of an image, checked before decoding (0 disables)")
+	maxConcurrency := fs.Int("max-concurrency", runtime.GOMAXPROCS(0), "maximum number of images decoded at once")
+	allowFetch := fs.Bool("allow-fetch", false, "allow hashing remote images by URL (public addresses only)")
+	fetchTimeout := fs.Duration("fetch-timeout", 30*time.Second, "maximum time to download a remote image")
+	indexFile := fs.String("index-file", "", "load the search index from this file and save it there on shutdown")
+	rest, err := parseArgs(fs, args)
+	if err == nil && len(rest) != 0 {
+		err = fmt.Errorf("unexpected arguments %q", rest)
+	}
+	if err == nil && (*maxBytes <= 0 || *maxConcurrency < 1) {
+		err = fmt.Errorf("--max-bytes and --max-concurrency must be positive")
+	}
+	if err == nil && *fetchTimeout <= 0 {
+		err = fmt.Errorf("--fetch-timeout must be positive")
+	}
+	if err == nil && *maxPixels < 0 {
+		err = fmt.Errorf("--max-pixels must be >= 0")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		fmt.Fprintln(os.Stderr, "usage:", serveUsage)
+		return 2
+	}
+
+	index, err := loadHashIndex(*indexFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	s := &server{
+		maxBytes:     *maxBytes,
+		limits:       phash.DecodeLimits{MaxPixels: *maxPixels},
+		allowFetch:   *allowFetch,
+		client:       publicOnlyClient(),
+		fetchTimeout: *fetchTimeout,
+		sem:          make(chan struct{}, *maxConcurrency),
+		index:        index,
+	}
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *addr)
+
+	code := 0
+	select {
+	case err := <-errc:
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		code = 1
+	}
+	if err := index.save(); err != nil {
+		fmt.Fprintln(os.Stderr, "error: saving index:", err)
+		return 1
+	}
+	return code
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hash", s.handleHashUpload)
+	mux.HandleFunc("GET /hash", s.handleHashURL)
+	mux.HandleFunc("POST /compare", s.handleCompare)
+	mux.HandleFunc("POST /index", s.handleIndexAdd)
+	mux.HandleFunc("POST /search", s.handleIndexSearch)
+	mux.HandleFunc("DELETE /index/{id}", s.handleIndexDelete)
+	return mux
+}
+
+// operand is an image given in a request: image bytes still to be decoded, or a parsed hash.
+type operand struct {
+	data []byte
+	hash phash.Hash
+}
+
+// handleHashUpload hashes a multipart upload (first file part) or the raw request body.
+func (s *server) handleHashUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.multipartLimit())
+	var res imageResult
+	op, err := s.readUpload(r)
+	if err == nil {
+		res, err = s.hashOne(r.Context(), op)
+	}
+	respond(w, res, err)
+}
+
+// handleHashURL hashes the image at ?url=.
+func (s *server) handleHashURL(w http.ResponseWriter, r *http.Request) {
+	var res imageResult
+	op, err := s.fetch(r.Context(), r.URL.Query().Get("url"))
+	if err == nil {
+		res, err = s.hashOne(r.Context(), op)
+	}
+	respond(w, res, err)
+}
+
+// handleCompare compares two images given as multipart parts "a" and "b" (file
+// uploads, or text fields holding a URL or hash), or as a JSON body {"a": ..., "b": ...}
+// whose values are URLs or hashes.
+func (s *server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 2*s.multipartLimit())
+	var res compareResult
+	err := func() error {
+		a, b, err := s.compareInputs(r)
+		if err != nil {
+			return err
+		}
+		results, err := s.hashOperands(r.Context(), a, b)
+		if err != nil {
+			return err
+		}
+		res.A, res.B = results[0], results[1]
+		res.Distance, err = res.A.hash.Distance(res.B.hash)
+		if err != nil {
+			return httpError{status: http.StatusBadRequest, err: err}
+		}
+		return nil
+	}()
+	respond(w, res, err)
+}
+
+func (s *server) compareInputs(r *http.Request) (operand, operand, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var req struct{ A, B string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return operand{}, operand{}, badRequestOr(err)
+		}
+		a, err := s.resolve(r.Context(), req.A)
+		if err != nil {
+			return operand{}, operand{}, err
+		}
+		b, err := s.resolve(r.Context(), req.B)
+		return a, b, err
+	}
+
+	if err := r.ParseMultipartForm(s.maxBytes); err != nil {
+		return operand{}, operand{}, badRequestOr(err)
+	}
+	defer r.MultipartForm.RemoveAll()
+	var out [2]operand
+	for i, name := range []string{"a", "b"} {
+		if f, hdr, err := r.FormFile(name); err == nil {
+			if hdr.Size > s.maxBytes {
+				f.Close()
+				return operand{}, operand{}, httpError{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("part %q exceeds %d bytes", name, s.maxBytes)}
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return operand{}, operand{}, badRequestOr(err)
+			}
+			out[i] = operand{data: data}
+			continue
+		}
+		op, err := s.resolve(r.Context(), r.FormValue(name))
+		if err != nil {
+			return operand{}, operand{}, err
+		}
+		out[i] = op
+	}
+	return out[0], out[1], nil
+}
+
+// resolve turns a compare operand into an operand: an http(s) URL is fetched,
+// anything else must parse as a hash.
+func (s *server) resolve(ctx context.Context, v string) (operand, error) {
+	if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+		return s.fetch(ctx, v)
+	}
+	h, err := phash.ParseHash(v)
+	if err != nil {
+		return operand{}, httpError{status: http.StatusBadRequest, err: fmt.Errorf("expected an image, URL or hash: %w", err)}
+	}
+	return operand{hash: h}, nil
+}
+
+// fetch downloads the image at url, bounded by maxBytes and fetchTimeout. It does
+// not hold a decode slot, so a slow upstream only ties up its own request.
+func (s *server) fetch(ctx context.Context, url string) (operand, error) {
+	if !s.allowFetch {
+		return operand{}, httpError{status: http.StatusForbidden, err: errors.New("fetching remote images is disabled")}
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return operand{}, httpError{status: http.StatusBadRequest, err: errors.New("url must be http or https")}
+	}
+	data, err := phash.Download(ctx, url, phash.DownloadOptions{
+		Client:        s.client,
+		Timeout:       s.fetchTimeout,
+		DecodeOptions: phash.DecodeOptions{MaxBytes: s.maxBytes},
+	})
+	var de phash.DecodeError
+	if errors.As(err, &de) && de.Op == phash.DecodeOpRead && !errors.Is(err, phash.ErrTooLarge) {
+		// The body comes from the remote server, so a failed or short read is an upstream failure.
+		err = httpError{status: http.StatusBadGateway, err: err}
+	}
+	return operand{data: data}, err
+}
+
+// hashOperands decodes and hashes the operands holding image bytes; hashes are
+// passed through. Only this CPU-bound step holds a decode slot: request bodies and
+// remote images are read beforehand, so slow clients cannot starve the server.
+func (s *server) hashOperands(ctx context.Context, ops ...operand) ([]imageResult, error) {
+	out := make([]imageResult, len(ops))
+	decode := false
+	for i, op := range ops {
+		if op.data == nil {
+			out[i] = imageResult{Hash: op.hash.Hex(), Algorithm: string(op.hash.Algorithm()), hash: op.hash}
+		} else {
+			decode = true
+		}
+	}
+	if !decode {
+		return out, nil
+	}
+	err := s.withSlot(ctx, func() error {
+		for i, op := range ops {
+			if op.data == nil {
+				continue
+			}
+			img, format, err := phash.DecodeAnyWithLimits(bytes.NewReader(op.data), s.limits)
+			if err != nil {
+				return err
+			}
+			if out[i], err = hashResult(img, format); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *server) hashOne(ctx context.Context, op operand) (imageResult, error) {
+	res, err := s.hashOperands(ctx, op)
+	if err != nil {
+		return imageResult{}, err
+	}
+	return res[0], nil
+}
+
+// errNonPublicAddress is returned when a fetch would connect to an internal address.
+var errNonPublicAddress = errors.New("refusing to fetch from a non-public address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicOnlyClient returns an HTTP client that refuses to connect to loopback,
+// private, link-local and other non-public addresses, so that fetching by URL
+// cannot reach internal services (e.g. 169.254.169.254). The check runs on the
+// resolved address of every connection, including those made for redirects.
+func publicOnlyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(ip) {
+				return fmt.Errorf("%w: %s", errNonPublicAddress, ip)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would connect on our behalf and bypass the check
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport}
+}
+
+// publicAddr reports whether ip is a globally routable unicast address.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// withSlot runs fn once a decode slot is free, or fails when ctx ends first.
+func (s *server) withSlot(ctx context.Context, fn func() error) error {
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return httpError{status: http.StatusServiceUnavailable, err: ctx.Err()}
+	}
+	defer func() { <-s.sem }()
+	return fn()
+}
+
+// multipartLimit allows some room for multipart headers around an image of maxBytes.
+func (s *server) multipartLimit() int64 { return s.maxBytes + 64<<10 }
+
+// readUpload reads the first file part of a multipart request, or the raw body.
+func (s *server) readUpload(r *http.Request) (operand, error) {
+	body, closeFn, err := uploadBody(r)
+	if err != nil {
+		return operand{}, err
+	}
+	defer closeFn()
+	data, err := io.ReadAll(io.LimitReader(body, s.maxBytes+1))
+	if err != nil {
+		return operand{}, badRequestOr(err)
+	}
+	if int64(len(data)) > s.maxBytes {
+		return operand{}, httpError{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("image exceeds %d bytes", s.maxBytes)}
+	}
+	return operand{data: data}, nil
+}
+
+// uploadBody returns the first file part of a multipart request, or the raw body.
+func uploadBody(r *http.Request) (io.Reader, func(), error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, func() {}, nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, httpError{status: http.StatusBadRequest, err: err}
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, nil, httpError{status: http.StatusBadRequest, err: errors.New("no file part in upload")}
+		}
+		if err != nil {
+			return nil, nil, badRequestOr(err)
+		}
+		if part.FileName() != "" {
+			return part, func() { part.Close() }, nil
+		}
+		part.Close()
+	}
+}
+
+func hashResult(img image.Image, format string) (imageResult, error) {
+	h, err := phash.HashImage(img, phash.AlgorithmPHash)
+	if err != nil {
+		return imageResult{}, err
+	}
+	w, hgt := imageSize(img)
+	return imageResult{Hash: h.Hex(), Algorithm: string(h.Algorithm()), Format: format, Width: w, Height: hgt, hash: h}, nil
+}
+
+func badRequestOr(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return httpError{status: http.StatusRequestEntityTooLarge, err: err}
+	}
+	return httpError{status: http.StatusBadRequest, err: err}
+}
+
+// respond writes v as JSON, or err as {"error": ...} with a matching status.
+func respond(w http.ResponseWriter, v any, err error) {
+	status := http.StatusOK
+	if err != nil {
+		status = errorStatus(err)
+		v = map[string]string{"error": err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func errorStatus(err error) int {
+	var (
+		he       httpError
+		tooLarge *http.MaxBytesError
+		de       phash.DecodeError
+	)
+	switch {
+	case errors.As(err, &he):
+		return he.status
+	case errors.Is(err, errNonPublicAddress):
+		return http.StatusForbidden
+	case errors.As(err, &tooLarge), errors.Is(err, phash.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &de):
+		switch de.Op {
+		case phash.DecodeOpRead, phash.DecodeOpRequest:
+			return http.StatusBadRequest
+		case phash.DecodeOpHTTP, phash.DecodeOpHTTPStatus, phash.DecodeOpContentType:
+			return http.StatusBadGateway
+		case phash.DecodeOpDecode, phash.DecodeOpFormat:
+			return http.StatusUnprocessableEntity
+		}
+	}
+	return http.StatusInternalServerError
+}
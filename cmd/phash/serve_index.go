@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+// Search defaults and limits for POST /search.
+const (
+	defaultSearchK      = 10
+	defaultSearchRadius = 10
+	maxSearchK          = 1000
+)
+
+// hashIndex is the server's similarity index, persisted to path on shutdown.
+type hashIndex struct {
+	mu   sync.RWMutex
+	idx  phash.Index
+	path string
+}
+
+// indexRequest is the body of POST /index and POST /search. Multipart forms and
+// raw image bodies (with id, k and radius as query parameters) are accepted as well.
+type indexRequest struct {
+	ID     string `json:"id"`
+	Hash   string `json:"hash"`
+	URL    string `json:"url"`
+	K      int    `json:"k"`
+	Radius *int   `json:"radius"`
+}
+
+type indexResult struct {
+	ID        string `json:"id"`
+	Hash      string `json:"hash"`
+	Algorithm string `json:"algorithm"`
+}
+
+type searchMatch struct {
+	ID        string `json:"id"`
+	Hash      string `json:"hash"`
+	Algorithm string `json:"algorithm"`
+	Distance  int    `json:"distance"`
+}
+
+type searchResult struct {
+	Query   imageResult   `json:"query"`
+	Matches []searchMatch `json:"matches"`
+}
+
+// loadHashIndex loads the index file at path into a MultiIndex, or starts an empty
+// one if the file does not exist.
+func loadHashIndex(path string) (*hashIndex, error) {
+	hi := &hashIndex{idx: phash.NewMultiIndex(0), path: path}
+	if path == "" {
+		return hi, nil
+	}
+	idx, hdr, err := phash.LoadIndexFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return hi, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Algorithm != phash.AlgorithmPHash {
+		return nil, fmt.Errorf("%s: index holds %s hashes, want %s", path, hdr.Algorithm, phash.AlgorithmPHash)
+	}
+	if _, ok := idx.(*phash.MultiIndex); ok {
+		hi.idx = idx
+		return hi, nil
+	}
+	// Other index kinds have O(n) removals; every POST /index removes, so rebuild.
+	for _, e := range idx.Entries() {
+		hi.idx.Add(e.Hash, e.ID)
+	}
+	return hi, nil
+}
+
+// save writes the index to its file, if one is configured.
+func (hi *hashIndex) save() error {
+	if hi.path == "" {
+		return nil
+	}
+	hi.mu.RLock()
+	defer hi.mu.RUnlock()
+	return phash.SaveIndexFile(hi.path, phash.AlgorithmPHash, hi.idx)
+}
+
+// handleIndexAdd stores (id, hash) for an uploaded image, an image URL or a hash.
+// An existing entry with the same id is replaced.
+func (s *server) handleIndexAdd(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.multipartLimit())
+	var res indexResult
+	err := func() error {
+		req, img, err := s.readIndexRequest(r)
+		if err != nil {
+			return err
+		}
+		if req.ID == "" {
+			return httpError{status: http.StatusBadRequest, err: errors.New("missing id")}
+		}
+		if err := indexable(img.hash); err != nil {
+			return err
+		}
+		s.index.mu.Lock()
+		s.index.idx.Remove(req.ID)
+		s.index.idx.Add(img.hash.Uint64(), req.ID)
+		s.index.mu.Unlock()
+		res = indexResult{ID: req.ID, Hash: img.Hash, Algorithm: img.Algorithm}
+		return nil
+	}()
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	respond(w, nil, err)
+}
+
+// handleIndexSearch returns the k nearest ids within radius of an image, URL or hash.
+func (s *server) handleIndexSearch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.multipartLimit())
+	var res searchResult
+	err := func() error {
+		req, img, err := s.readIndexRequest(r)
+		if err != nil {
+			return err
+		}
+		if err := indexable(img.hash); err != nil {
+			return err
+		}
+		k, radius := req.K, defaultSearchRadius
+		if k == 0 {
+			k = defaultSearchK
+		}
+		if req.Radius != nil {
+			radius = *req.Radius
+		}
+		if k < 0 || k > maxSearchK || radius < 0 || radius > 64 {
+			return httpError{status: http.StatusBadRequest, err: fmt.Errorf("k must be in [1, %d] and radius in [0, 64]", maxSearchK)}
+		}
+
+		s.index.mu.RLock()
+		matches := s.index.idx.Search(img.hash.Uint64(), radius)
+		s.index.mu.RUnlock()
+		if len(matches) > k {
+			matches = matches[:k]
+		}
+		res = searchResult{Query: img, Matches: make([]searchMatch, len(matches))}
+		for i, m := range matches {
+			h, err := phash.NewHash(phash.AlgorithmPHash, m.Hash)
+			if err != nil {
+				return err
+			}
+			res.Matches[i] = searchMatch{ID: m.ID, Hash: h.Hex(), Algorithm: string(h.Algorithm()), Distance: m.Distance}
+		}
+		return nil
+	}()
+	respond(w, res, err)
+}
+
+// handleIndexDelete removes every entry with the given id.
+func (s *server) handleIndexDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.index.mu.Lock()
+	n := s.index.idx.Remove(id)
+	s.index.mu.Unlock()
+	if n == 0 {
+		respond(w, nil, httpError{status: http.StatusNotFound, err: fmt.Errorf("id %q not found", id)})
+		return
+	}
+	respond(w, map[string]any{"id": id, "removed": n}, nil)
+}
+
+// readIndexRequest parses an index or search request and hashes its image.
+// The image comes from, in order: an uploaded file (multipart part "file" or a
+// raw non-JSON body), the "hash" field, or the "url" field.
+func (s *server) readIndexRequest(r *http.Request) (indexRequest, imageResult, error) {
+	var req indexRequest
+	var upload []byte
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, imageResult{}, badRequestOr(err)
+		}
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(s.maxBytes); err != nil {
+			return req, imageResult{}, badRequestOr(err)
+		}
+		defer r.MultipartForm.RemoveAll()
+		if err := formValues(&req, r.FormValue); err != nil {
+			return req, imageResult{}, err
+		}
+		if f, hdr, err := r.FormFile("file"); err == nil {
+			defer f.Close()
+			if hdr.Size > s.maxBytes {
+				return req, imageResult{}, httpError{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("image exceeds %d bytes", s.maxBytes)}
+			}
+			if upload, err = io.ReadAll(f); err != nil {
+				return req, imageResult{}, badRequestOr(err)
+			}
+		}
+	default:
+		if err := formValues(&req, r.URL.Query().Get); err != nil {
+			return req, imageResult{}, err
+		}
+		b, err := io.ReadAll(io.LimitReader(r.Body, s.maxBytes+1))
+		if err != nil {
+			return req, imageResult{}, badRequestOr(err)
+		}
+		if int64(len(b)) > s.maxBytes {
+			return req, imageResult{}, httpError{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("image exceeds %d bytes", s.maxBytes)}
+		}
+		upload = b
+	}
+
+	op, err := s.requestOperand(r.Context(), req, upload)
+	if err != nil {
+		return req, imageResult{}, err
+	}
+	img, err := s.hashOne(r.Context(), op)
+	return req, img, err
+}
+
+func (s *server) requestOperand(ctx context.Context, req indexRequest, upload []byte) (operand, error) {
+	switch {
+	case len(upload) > 0:
+		return operand{data: upload}, nil
+	case req.Hash != "":
+		return s.resolve(ctx, req.Hash)
+	case req.URL != "":
+		return s.fetch(ctx, req.URL)
+	default:
+		return operand{}, httpError{status: http.StatusBadRequest, err: errors.New("expected an image upload, hash or url")}
+	}
+}
+
+// formValues fills req from form fields or query parameters.
+func formValues(req *indexRequest, get func(string) string) error {
+	req.ID, req.Hash, req.URL = get("id"), get("hash"), get("url")
+	if v := get("k"); v != "" {
+		k, err := strconv.Atoi(v)
+		if err != nil {
+			return httpError{status: http.StatusBadRequest, err: fmt.Errorf("k: %w", err)}
+		}
+		req.K = k
+	}
+	if v := get("radius"); v != "" {
+		radius, err := strconv.Atoi(v)
+		if err != nil {
+			return httpError{status: http.StatusBadRequest, err: fmt.Errorf("radius: %w", err)}
+		}
+		req.Radius = &radius
+	}
+	return nil
+}
+
+// indexable reports whether h can be stored in the index (64-bit pHash only).
+func indexable(h phash.Hash) error {
+	if h.Algorithm() != phash.AlgorithmPHash || h.Bits() != 64 {
+		return httpError{status: http.StatusBadRequest, err: fmt.Errorf("%w: the index holds 64-bit phash values", phash.ErrHashMismatch)}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+func TestServeIndexAddReplace(t *testing.T) {
+	s := newTestServer(t)
+	ct, form := multipartBody(t, map[string][]byte{"file": readSweater(t)}, map[string]string{"id": "sku-1"})
+	var added indexResult
+	if code := serveRequest(t, s, http.MethodPost, "/index", ct, form.Bytes(), &added); code != http.StatusCreated {
+		t.Fatalf("multipart add: status %d, want 201", code)
+	}
+	if added.ID != "sku-1" || added.Hash != sweaterHash {
+		t.Fatalf("multipart add = %+v", added)
+	}
+
+	// Re-adding the id replaces its hash instead of storing a second entry.
+	if code := serveRequest(t, s, http.MethodPost, "/index", "application/json", []byte(`{"id":"sku-1","hash":"0000000000000000"}`), nil); code != http.StatusCreated {
+		t.Fatalf("replace: status %d, want 201", code)
+	}
+	if got := s.index.idx.Entries(); len(got) != 1 || got[0] != (phash.Entry{ID: "sku-1", Hash: 0}) {
+		t.Fatalf("entries after replace = %v, want only sku-1 => 0", got)
+	}
+
+	// Raw image bodies take the id from the query.
+	if code := serveRequest(t, s, http.MethodPost, "/index?id=sku-2", "image/jpeg", readSweater(t), nil); code != http.StatusCreated {
+		t.Fatalf("raw add: status %d, want 201", code)
+	}
+	if s.index.idx.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", s.index.idx.Len())
+	}
+
+	for _, tc := range []struct {
+		name, body string
+	}{
+		{"missing id", `{"hash":"` + sweaterHash + `"}`},
+		{"wrong algorithm", `{"id":"x","hash":"ahash:` + sweaterHash + `"}`},
+		{"no image", `{"id":"x"}`},
+	} {
+		if code := serveRequest(t, s, http.MethodPost, "/index", "application/json", []byte(tc.body), nil); code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", tc.name, code)
+		}
+	}
+}
+
+func TestServeIndexSearch(t *testing.T) {
+	s := newTestServer(t)
+	for id, h := range map[string]uint64{"a": 0x0, "b": 0x1, "c": 0x3, "d": 0xff} {
+		s.index.idx.Add(h, id)
+	}
+
+	for _, tc := range []struct {
+		body string
+		want []string
+	}{
+		{`{"hash":"0000000000000000"}`, []string{"a", "b", "c", "d"}},
+		{`{"hash":"0000000000000000","radius":2}`, []string{"a", "b", "c"}},
+		{`{"hash":"0000000000000000","k":2}`, []string{"a", "b"}},
+		{`{"hash":"0000000000000000","radius":0}`, []string{"a"}},
+	} {
+		var res searchResult
+		if code := serveRequest(t, s, http.MethodPost, "/search", "application/json", []byte(tc.body), &res); code != http.StatusOK {
+			t.Fatalf("%s: status %d", tc.body, code)
+		}
+		var ids []string
+		for _, m := range res.Matches {
+			ids = append(ids, m.ID)
+			if m.Algorithm != string(phash.AlgorithmPHash) || len(m.Hash) != 16 {
+				t.Fatalf("%s: match %+v, want a 16-digit phash", tc.body, m)
+			}
+		}
+		if len(ids) != len(tc.want) {
+			t.Fatalf("%s: matches %v, want %v", tc.body, ids, tc.want)
+		}
+		for i := range ids {
+			if ids[i] != tc.want[i] {
+				t.Fatalf("%s: matches %v, want %v", tc.body, ids, tc.want)
+			}
+		}
+	}
+
+	for _, body := range []string{
+		`{"hash":"0000000000000000","k":-1}`,
+		`{"hash":"0000000000000000","k":100000}`,
+		`{"hash":"0000000000000000","radius":65}`,
+	} {
+		if code := serveRequest(t, s, http.MethodPost, "/search", "application/json", []byte(body), nil); code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", body, code)
+		}
+	}
+}
+
+func TestServeIndexDelete(t *testing.T) {
+	s := newTestServer(t)
+	s.index.idx.Add(0x1, "sku-1")
+	if code := serveRequest(t, s, http.MethodDelete, "/index/sku-1", "", nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: status %d, want 200", code)
+	}
+	if code := serveRequest(t, s, http.MethodDelete, "/index/sku-1", "", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("second delete: status %d, want 404", code)
+	}
+}
+
+func TestHashIndexSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.phix")
+	hi, err := loadHashIndex(path) // a missing file starts an empty index
+	if err != nil {
+		t.Fatal(err)
+	}
+	hi.idx.Add(0xfa85955a872769cb, "sweater")
+	hi.idx.Add(0x1, "other")
+	if err := hi.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadHashIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.idx.(*phash.MultiIndex); !ok {
+		t.Fatalf("loaded index is %T, want *phash.MultiIndex", loaded.idx)
+	}
+	if got := loaded.idx.Search(0xfa85955a872769cb, 0); len(got) != 1 || got[0].ID != "sweater" {
+		t.Fatalf("search after reload = %v", got)
+	}
+
+	// BK-tree files are converted to a MultiIndex for O(k) replacement.
+	tree := phash.NewBKTree()
+	tree.Add(0x1, "tree")
+	if err := phash.SaveIndexFile(path, phash.AlgorithmPHash, tree); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = loadHashIndex(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.idx.(*phash.MultiIndex); !ok || loaded.idx.Len() != 1 {
+		t.Fatalf("loaded BK-tree file as %T with %d entries", loaded.idx, loaded.idx.Len())
+	}
+
+	if err := phash.SaveIndexFile(path, phash.AlgorithmAHash, tree); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadHashIndex(path); err == nil {
+		t.Fatal("loading an ahash index succeeded")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strconv"
+	"testing"
+
+	phash "github.com/enot-style/go-phash"
+)
+
+const sweaterHash = "fa85955a872769cb"
+
+// newTestServer returns a server with an empty in-memory index and room for
+// images of up to 1 MiB.
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	index, err := loadHashIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &server{maxBytes: 1 << 20, sem: make(chan struct{}, 2), index: index}
+}
+
+func readSweater(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile("../../test_data/sweater-thumb.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// multipartBody encodes files (part name -> contents) and fields as a multipart form.
+func multipartBody(t *testing.T, files map[string][]byte, fields map[string]string) (string, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, data := range files {
+		fw, err := mw.CreateFormFile(name, name+".jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	for name, v := range fields {
+		if err := mw.WriteField(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return mw.FormDataContentType(), &buf
+}
+
+// serveRequest runs one request against s and decodes the JSON response into out, if non-nil.
+func serveRequest(t *testing.T, s *server, method, target, contentType string, body []byte, out any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("%s %s: Content-Type %q, want application/json", method, target, got)
+	}
+	if out != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, target, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+func TestServeHashUploads(t *testing.T) {
+	s := newTestServer(t)
+	img := readSweater(t)
+	ct, form := multipartBody(t, map[string][]byte{"file": img}, nil)
+
+	for _, tc := range []struct {
+		name, contentType string
+		body              []byte
+	}{
+		{"raw", "image/jpeg", img},
+		{"multipart", ct, form.Bytes()},
+	} {
+		var res imageResult
+		if code := serveRequest(t, s, http.MethodPost, "/hash", tc.contentType, tc.body, &res); code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", tc.name, code)
+		}
+		if res.Hash != sweaterHash || res.Format != "jpeg" || res.Width != 190 || res.Height != 128 {
+			t.Fatalf("%s: got %+v", tc.name, res)
+		}
+	}
+}
+
+func TestServeErrorStatus(t *testing.T) {
+	img := readSweater(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case "/short":
+			w.Header().Set("Content-Length", strconv.Itoa(len(img)+100))
+			w.Write(img)
+		default:
+			w.Write(img)
+		}
+	}))
+	defer upstream.Close()
+
+	for _, tc := range []struct {
+		name      string
+		configure func(*server)
+		method    string
+		target    string
+		ctype     string
+		body      []byte
+		want      int
+	}{
+		{"undecodable", nil, http.MethodPost, "/hash", "image/jpeg", []byte("not an image"), http.StatusUnprocessableEntity},
+		{"over max-bytes", func(s *server) { s.maxBytes = 1000 }, http.MethodPost, "/hash", "image/jpeg", img, http.StatusRequestEntityTooLarge},
+		{"over max-pixels", func(s *server) { s.limits.MaxPixels = 100 }, http.MethodPost, "/hash", "image/jpeg", img, http.StatusRequestEntityTooLarge},
+		{"bad compare operand", nil, http.MethodPost, "/compare", "application/json", []byte(`{"a":"zz","b":"` + sweaterHash + `"}`), http.StatusBadRequest},
+		{"incomparable hashes", nil, http.MethodPost, "/compare", "application/json", []byte(`{"a":"ahash:` + sweaterHash + `","b":"` + sweaterHash + `"}`), http.StatusBadRequest},
+		{"fetch disabled", nil, http.MethodGet, "/hash?url=" + upstream.URL, "", nil, http.StatusForbidden},
+		{"upstream error", func(s *server) { s.allowFetch = true }, http.MethodGet, "/hash?url=" + upstream.URL + "/broken", "", nil, http.StatusBadGateway},
+		{"upstream truncated", func(s *server) { s.allowFetch = true }, http.MethodGet, "/hash?url=" + upstream.URL + "/short", "", nil, http.StatusBadGateway},
+		{"upstream over max-bytes", func(s *server) { s.allowFetch, s.maxBytes = true, 1000 }, http.MethodGet, "/hash?url=" + upstream.URL, "", nil, http.StatusRequestEntityTooLarge},
+		{"not http", func(s *server) { s.allowFetch = true }, http.MethodGet, "/hash?url=file:///etc/passwd", "", nil, http.StatusBadRequest},
+	} {
+		s := newTestServer(t)
+		s.client = upstream.Client()
+		if tc.configure != nil {
+			tc.configure(s)
+		}
+		if got := serveRequest(t, s, tc.method, tc.target, tc.ctype, tc.body, nil); got != tc.want {
+			t.Fatalf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestServeMultipartLimits(t *testing.T) {
+	img := readSweater(t)
+	s := newTestServer(t)
+	s.maxBytes = 1000
+	ct, form := multipartBody(t, map[string][]byte{"file": img}, nil)
+	if code := serveRequest(t, s, http.MethodPost, "/hash", ct, form.Bytes(), nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("multipart over max-bytes: status %d, want 413", code)
+	}
+
+	s = newTestServer(t)
+	s.limits.MaxPixels = 100
+	ct, form = multipartBody(t, map[string][]byte{"a": img}, map[string]string{"b": sweaterHash})
+	if code := serveRequest(t, s, http.MethodPost, "/compare", ct, form.Bytes(), nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("multipart compare over max-pixels: status %d, want 413", code)
+	}
+}
+
+func TestServeCompare(t *testing.T) {
+	img := readSweater(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(img) }))
+	defer upstream.Close()
+	s := newTestServer(t)
+	s.allowFetch, s.client = true, upstream.Client()
+
+	ct, form := multipartBody(t, map[string][]byte{"a": img}, map[string]string{"b": "0000000000000000"})
+	var res compareResult
+	if code := serveRequest(t, s, http.MethodPost, "/compare", ct, form.Bytes(), &res); code != http.StatusOK {
+		t.Fatalf("multipart compare: status %d", code)
+	}
+	h, _ := phash.ParseHash(sweaterHash)
+	if want := phash.HammingDistance(h.Uint64(), 0); res.Distance != want || res.A.Hash != sweaterHash {
+		t.Fatalf("multipart compare = %+v, want distance %d", res, want)
+	}
+
+	body := []byte(`{"a":"` + upstream.URL + `","b":"` + sweaterHash + `"}`)
+	if code := serveRequest(t, s, http.MethodPost, "/compare", "application/json", body, &res); code != http.StatusOK || res.Distance != 0 {
+		t.Fatalf("JSON compare with URL: status %d, %+v", code, res)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"100.100.100.200", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+		{"fd00::1", false},
+	} {
+		if got := publicAddr(netip.MustParseAddr(tc.addr)); got != tc.want {
+			t.Fatalf("publicAddr(%s) = %v, want %v", tc.addr, got, tc.want)
+		}
+	}
+}
@@ -4,106 +4,165 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
 	"io"
-	"net/http"
+	"slices"
 
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 
 	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
 	_ "golang.org/x/image/webp"
 )
 
-// DecodeAny reads all bytes (so it works with non-seekable readers), decodes, and applies EXIF orientation.
+// DecodeOptions configures Decode and, embedded in DownloadOptions, DownloadAndDecode.
+// The zero value reads the whole input, accepts every registered format at any size,
+// and applies EXIF orientation.
+type DecodeOptions struct {
+	// MaxBytes caps the input size; longer input fails with ErrTooLarge. Zero means unlimited.
+	MaxBytes int64
+	// Limits bounds the image dimensions, checked before the pixel data is decoded.
+	Limits DecodeLimits
+	// SkipOrientation keeps the stored pixel layout instead of applying EXIF orientation.
+	SkipOrientation bool
+	// Formats lists the accepted format names as reported by image.Decode
+	// ("jpeg", "png", "gif", "bmp", "webp", ...). Empty accepts every registered format.
+	Formats []string
+	// MaxSide, if non-zero, downscales the decoded image so its largest side is at most
+	// MaxSide pixels (see DownscaleByLargestSide). The downscale runs after the full
+	// decode, so it bounds the size of the result, not the cost of decoding;
+	// use Limits to bound that.
+	MaxSide uint32
+}
+
+// Decode reads all bytes (so it works with non-seekable readers) and decodes them according to opts.
 // It returns the decoded image and the detected format string ("jpeg", "png", "gif", "webp", ...).
-// Errors are returned as DecodeError with Op "read" or "decode".
-func DecodeAny(r io.Reader) (image.Image, string, error) {
-	b, err := io.ReadAll(r)
+// Errors are returned as DecodeError with Op "read", "format", "limits", or "decode".
+func Decode(r io.Reader, opts DecodeOptions) (image.Image, string, error) {
+	b, err := readAll(r, opts.MaxBytes)
 	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpRead, Err: err}
+		return nil, "", err
 	}
-	return decodeBytes(b)
+	return decodeBytes(b, opts)
 }
 
-// DownloadAndDecodeAny fetches a remote image over HTTP, decodes it, and applies EXIF orientation.
-// Errors are returned as DecodeError with Op "request", "http", "http status", or "decode".
-func DownloadAndDecodeAny(ctx context.Context, url string) (image.Image, string, error) {
-	var (
-		req *http.Request
-		err error
-	)
-	if ctx == nil {
-		req, err = http.NewRequest(http.MethodGet, url, nil)
-	} else {
-		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// readAll reads r to the end. With a positive maxBytes, input longer than maxBytes
+// fails with ErrTooLarge instead of being cut off.
+// Errors are returned as DecodeError with Op "read".
+func readAll(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
 	}
+	b, err := io.ReadAll(r)
 	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpRequest, Err: err}
+		return nil, DecodeError{Op: DecodeOpRead, Err: decodeCause(err)}
 	}
-	req.Header.Set("Accept", "image/*,*/*;q=0.8")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpHTTP, Err: err}
+	if maxBytes > 0 && int64(len(b)) > maxBytes {
+		return nil, DecodeError{Op: DecodeOpRead, Err: fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxBytes)}
 	}
-	defer resp.Body.Close()
+	return b, nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, "", DecodeError{Op: DecodeOpHTTPStatus, Err: fmt.Errorf("%d (%s)", resp.StatusCode, resp.Status)}
-	}
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpRead, Err: err}
-	}
-	return decodeBytes(b)
+// DecodeAny reads all bytes (so it works with non-seekable readers), decodes, and applies EXIF orientation.
+// It returns the decoded image and the detected format string ("jpeg", "png", "gif", "webp", ...).
+// Errors are returned as DecodeError with Op "read" or "decode".
+func DecodeAny(r io.Reader) (image.Image, string, error) {
+	return Decode(r, DecodeOptions{})
+}
+
+// DownloadAndDecodeAny fetches a remote image over HTTP, decodes it, and applies EXIF orientation.
+// Errors are returned as DecodeError with Op "request", "http", "http status", "read", or "decode".
+func DownloadAndDecodeAny(ctx context.Context, url string) (image.Image, string, error) {
+	return DownloadAndDecode(ctx, url, DownloadOptions{})
 }
 
 // DownloadAndDecodeAnyWithLimit fetches a remote image over HTTP, decodes it with a byte cap, and applies EXIF orientation.
-// Errors are returned as DecodeError with Op "request", "http", "http status", or "decode".
+// maxBytes must be positive; use DownloadAndDecodeAny for no cap.
+// Errors are returned as DecodeError with Op "request", "http", "http status", "read", or "decode".
 func DownloadAndDecodeAnyWithLimit(ctx context.Context, url string, maxBytes int64) (image.Image, string, error) {
-	var (
-		req *http.Request
-		err error
-	)
-	if ctx == nil {
-		req, err = http.NewRequest(http.MethodGet, url, nil)
-	} else {
-		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	}
-	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpRequest, Err: err}
+	if maxBytes <= 0 {
+		return nil, "", DecodeError{Op: DecodeOpRequest, Err: fmt.Errorf("maxBytes must be positive, got %d", maxBytes)}
 	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpHTTP, Err: err}
+	return DownloadAndDecode(ctx, url, DownloadOptions{DecodeOptions: DecodeOptions{MaxBytes: maxBytes}})
+}
+
+// DecodeLimits bounds the dimensions of images accepted for decoding.
+// They are checked against the header via image.DecodeConfig before any pixel data
+// is decoded, so a small file declaring a huge canvas is rejected without allocating it.
+// Zero fields are unlimited.
+type DecodeLimits struct {
+	MaxPixels int64 // width * height
+	MaxWidth  int
+	MaxHeight int
+}
+
+// check returns an error describing the first limit exceeded by cfg, or nil.
+func (l DecodeLimits) check(cfg image.Config) error {
+	switch {
+	case l.MaxWidth > 0 && cfg.Width > l.MaxWidth:
+		return fmt.Errorf("%w: width %d exceeds %d", ErrTooLarge, cfg.Width, l.MaxWidth)
+	case l.MaxHeight > 0 && cfg.Height > l.MaxHeight:
+		return fmt.Errorf("%w: height %d exceeds %d", ErrTooLarge, cfg.Height, l.MaxHeight)
+	case l.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > l.MaxPixels:
+		return fmt.Errorf("%w: %dx%d pixels exceed %d", ErrTooLarge, cfg.Width, cfg.Height, l.MaxPixels)
 	}
-	defer resp.Body.Close()
+	return nil
+}
+
+// DecodeAnyWithLimits is DecodeAny with dimension limits checked before the full decode.
+// Errors are returned as DecodeError with Op "read", "limits", or "decode".
+func DecodeAnyWithLimits(r io.Reader, limits DecodeLimits) (image.Image, string, error) {
+	return Decode(r, DecodeOptions{Limits: limits})
+}
 
-	if resp.StatusCode/100 != 2 {
-		return nil, "", DecodeError{Op: DecodeOpHTTPStatus, Err: fmt.Errorf("%d (%s)", resp.StatusCode, resp.Status)}
+// decodeBytes decodes an image from bytes according to opts. The header is checked
+// against opts.Formats and opts.Limits before the full decode.
+// Errors are returned as DecodeError with Op "format", "limits", or "decode".
+func decodeBytes(b []byte, opts DecodeOptions) (image.Image, string, error) {
+	if len(opts.Formats) > 0 || opts.Limits != (DecodeLimits{}) {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return nil, "", DecodeError{Op: DecodeOpDecode, Err: decodeCause(err)}
+		}
+		if len(opts.Formats) > 0 && !slices.Contains(opts.Formats, format) {
+			return nil, "", DecodeError{Op: DecodeOpFormat, Err: fmt.Errorf("%q not allowed", format)}
+		}
+		if err := opts.Limits.check(cfg); err != nil {
+			return nil, "", DecodeError{Op: DecodeOpLimits, Err: err}
+		}
 	}
-	limited := io.LimitReader(resp.Body, maxBytes)
-	b, err := io.ReadAll(limited)
+	img, format, err := image.Decode(bytes.NewReader(b))
 	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpRead, Err: err}
+		return nil, "", DecodeError{Op: DecodeOpDecode, Err: decodeCause(err)}
+	}
+	if opts.MaxSide > 0 {
+		img = DownscaleByLargestSide(img, opts.MaxSide)
+	}
+	if !opts.SkipOrientation {
+		img = applyEXIFOrientation(img, b)
 	}
-	return decodeBytes(b)
+	return img, format, nil
 }
 
-// decodeBytes decodes an image from bytes and normalizes it using EXIF orientation (JPEG only).
-// Errors are returned as DecodeError with Op "decode".
-func decodeBytes(b []byte) (image.Image, string, error) {
-	img, format, err := image.Decode(bytes.NewReader(b))
-	if err != nil {
-		return nil, "", DecodeError{Op: DecodeOpDecode, Err: err}
+// decodeCause wraps errors of the image package and the registered decoders with
+// ErrUnknownFormat or ErrTruncated, keeping the original error in the chain;
+// other errors are returned unchanged.
+func decodeCause(err error) error {
+	switch {
+	case errors.Is(err, image.ErrFormat):
+		return fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: %w", ErrTruncated, err)
 	}
-	return applyEXIFOrientation(img, b), format, nil
+	return err
 }
 
-// applyEXIFOrientation returns an image rotated/flipped per EXIF orientation if present.
+// applyEXIFOrientation returns an image rotated/flipped per EXIF orientation (JPEG) or
+// the Orientation tag (TIFF) if present.
 // It never returns errors; missing or invalid EXIF keeps the original image.
 //
 // Orientation values (EXIF):
@@ -118,6 +177,9 @@ func decodeBytes(b []byte) (image.Image, string, error) {
 //	8: rotate 270 CW
 func applyEXIFOrientation(img image.Image, payload []byte) image.Image {
 	orientation, ok := exifOrientationJPEG(payload)
+	if !ok {
+		orientation, ok = orientationTIFF(payload)
+	}
 	if !ok || orientation == 1 {
 		return img
 	}
@@ -205,6 +267,15 @@ func exifOrientationJPEG(data []byte) (int, bool) {
 
 var exifHeader = []byte("Exif\x00\x00")
 
+// orientationTIFF reads the Orientation tag from the first IFD of a TIFF payload.
+// It returns the orientation value (1..8) and true on success.
+func orientationTIFF(data []byte) (int, bool) {
+	if !bytes.HasPrefix(data, []byte("II*\x00")) && !bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return 0, false
+	}
+	return parseExifOrientation(data)
+}
+
 // parseExifOrientation parses TIFF payload and extracts the Orientation tag if present.
 // It returns the orientation value (1..8) and true on success.
 func parseExifOrientation(tiff []byte) (int, bool) {
@@ -0,0 +1,200 @@
+package phash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+// pngWithSize returns a valid PNG whose IHDR chunk is rewritten to declare w x h pixels.
+func pngWithSize(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	b := testPNG(t)
+	ihdr := b[8+8 : 8+8+13] // after the signature and the chunk length/type
+	binary.BigEndian.PutUint32(ihdr[0:4], w)
+	binary.BigEndian.PutUint32(ihdr[4:8], h)
+	binary.BigEndian.PutUint32(b[8+8+13:], crc32.ChecksumIEEE(b[8+4:8+8+13]))
+	return b
+}
+
+func TestDecodeAnyWithLimits(t *testing.T) {
+	bomb := pngWithSize(t, 60000, 60000)
+	for _, limits := range []DecodeLimits{
+		{MaxPixels: 100_000_000},
+		{MaxWidth: 10000},
+		{MaxHeight: 10000},
+	} {
+		_, _, err := DecodeAnyWithLimits(bytes.NewReader(bomb), limits)
+		var de DecodeError
+		if !errors.As(err, &de) || de.Op != DecodeOpLimits {
+			t.Fatalf("limits %+v: err = %v, want %q error", limits, err, DecodeOpLimits)
+		}
+	}
+
+	img, _, err := DecodeAnyWithLimits(bytes.NewReader(testPNG(t)), DecodeLimits{MaxPixels: 12, MaxWidth: 4, MaxHeight: 3})
+	if err != nil {
+		t.Fatalf("image within limits: %v", err)
+	}
+	if img.Bounds().Dx() != 4 {
+		t.Fatalf("decoded width %d, want 4", img.Bounds().Dx())
+	}
+}
+
+func TestDecodeAnySentinelErrors(t *testing.T) {
+	png := testPNG(t)
+	for _, tc := range []struct {
+		name       string
+		data       []byte
+		want, orig error
+	}{
+		{"unknown format", []byte("not an image at all"), ErrUnknownFormat, image.ErrFormat},
+		{"truncated", png[:len(png)/2], ErrTruncated, io.ErrUnexpectedEOF},
+	} {
+		_, _, err := DecodeAny(bytes.NewReader(tc.data))
+		if !errors.Is(err, tc.want) || !errors.Is(err, tc.orig) {
+			t.Fatalf("%s: err = %v, want both %v and %v", tc.name, err, tc.want, tc.orig)
+		}
+		var de DecodeError
+		if !errors.As(err, &de) || de.Op != DecodeOpDecode {
+			t.Fatalf("%s: err = %v, want %q DecodeError", tc.name, err, DecodeOpDecode)
+		}
+	}
+
+	_, _, err := DecodeAnyWithLimits(bytes.NewReader(pngWithSize(t, 60000, 60000)), DecodeLimits{MaxPixels: 1 << 20})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("limits: err = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+// exifTIFF returns a little-endian TIFF header with a single IFD holding the Orientation tag.
+func exifTIFF(orientation uint16) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	b = binary.LittleEndian.AppendUint16(b, 1)      // entry count
+	b = binary.LittleEndian.AppendUint16(b, 0x0112) // Orientation
+	b = binary.LittleEndian.AppendUint16(b, 3)      // SHORT
+	b = binary.LittleEndian.AppendUint32(b, 1)      // count
+	b = binary.LittleEndian.AppendUint16(b, orientation)
+	b = append(b, 0, 0)                           // value padding
+	return binary.LittleEndian.AppendUint32(b, 0) // no next IFD
+}
+
+// jpegWithOrientation returns a w x h JPEG carrying an EXIF APP1 segment with the given orientation.
+func jpegWithOrientation(t *testing.T, w, h int, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	segment := append([]byte("Exif\x00\x00"), exifTIFF(orientation)...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+	b := buf.Bytes()
+	return append(append(append([]byte{}, b[:2]...), app1...), b[2:]...)
+}
+
+func TestDecodeOptions(t *testing.T) {
+	rotated := jpegWithOrientation(t, 40, 20, 6)
+
+	img, format, err := Decode(bytes.NewReader(rotated), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 {
+		t.Fatalf("default: got %s %v, want jpeg rotated to 20x40", format, img.Bounds())
+	}
+
+	img, _, err = Decode(bytes.NewReader(rotated), DecodeOptions{SkipOrientation: true, MaxSide: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 10 || img.Bounds().Dy() != 5 {
+		t.Fatalf("SkipOrientation+MaxSide: got %v, want 10x5", img.Bounds())
+	}
+
+	_, _, err = Decode(bytes.NewReader(rotated), DecodeOptions{Formats: []string{"png", "webp"}})
+	var de DecodeError
+	if !errors.As(err, &de) || de.Op != DecodeOpFormat {
+		t.Fatalf("Formats: err = %v, want %q error", err, DecodeOpFormat)
+	}
+	if _, _, err := Decode(bytes.NewReader(rotated), DecodeOptions{Formats: []string{"jpeg"}}); err != nil {
+		t.Fatalf("allowed format: %v", err)
+	}
+}
+
+func TestDecodeMaxBytes(t *testing.T) {
+	b := testPNG(t)
+	if _, _, err := Decode(bytes.NewReader(b), DecodeOptions{MaxBytes: int64(len(b)) - 1}); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want %v", err, ErrTooLarge)
+	}
+	if _, _, err := Decode(bytes.NewReader(b), DecodeOptions{MaxBytes: int64(len(b))}); err != nil {
+		t.Fatalf("input of exactly MaxBytes: %v", err)
+	}
+}
+
+// grayTIFF returns an uncompressed little-endian 8-bit grayscale TIFF of w x h pixels
+// with the given Orientation tag.
+func grayTIFF(pix []byte, w, h int, orientation uint16) []byte {
+	type entry struct{ tag, typ, value uint16 }
+	const dataOffset = 8 + 2 + 9*12 + 4 // header, IFD with nine entries
+	entries := []entry{
+		{256, 3, uint16(w)},        // ImageWidth
+		{257, 3, uint16(h)},        // ImageLength
+		{258, 3, 8},                // BitsPerSample
+		{259, 3, 1},                // Compression: none
+		{262, 3, 1},                // PhotometricInterpretation: black is zero
+		{273, 3, dataOffset},       // StripOffsets
+		{274, 3, orientation},      // Orientation
+		{278, 3, uint16(h)},        // RowsPerStrip
+		{279, 3, uint16(len(pix))}, // StripByteCounts
+	}
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(entries)))
+	for _, e := range entries {
+		b = binary.LittleEndian.AppendUint16(b, e.tag)
+		b = binary.LittleEndian.AppendUint16(b, e.typ)
+		b = binary.LittleEndian.AppendUint32(b, 1)
+		b = binary.LittleEndian.AppendUint16(b, e.value)
+		b = append(b, 0, 0)
+	}
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	return append(b, pix...)
+}
+
+func TestDecodeTIFFOrientation(t *testing.T) {
+	pix := []byte{10, 20, 30, 40, 50, 60} // 3x2
+	data := grayTIFF(pix, 3, 2, 6)
+
+	stored, format, err := Decode(bytes.NewReader(data), DecodeOptions{SkipOrientation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "tiff" || stored.Bounds().Dx() != 3 || stored.Bounds().Dy() != 2 {
+		t.Fatalf("got %s %v, want tiff 3x2", format, stored.Bounds())
+	}
+
+	img, _, err := DecodeAny(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rotate90(stored)
+	if img.Bounds() != want.Bounds() {
+		t.Fatalf("oriented bounds %v, want %v", img.Bounds(), want.Bounds())
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			if img.At(x, y) != want.At(x, y) {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, img.At(x, y), want.At(x, y))
+			}
+		}
+	}
+
+	if o, ok := orientationTIFF(grayTIFF(pix, 3, 2, 1)); !ok || o != 1 {
+		t.Fatalf("orientationTIFF = %d, %v, want 1, true", o, ok)
+	}
+}
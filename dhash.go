@@ -0,0 +1,58 @@
+package phash
+
+import "image"
+
+// DHash computes a 64-bit horizontal difference hash (dHash).
+//
+// Pipeline:
+//  1. Grayscale
+//  2. Resize to 9x8
+//  3. For each row, compare every pixel with its right neighbour
+//  4. Build 64-bit hash: bit=1 if right>left, row-major, MSB-first
+func DHash(image image.Image) uint64 {
+	if image == nil {
+		return 0
+	}
+	pix := grayMatrix(Resize(Grayscale(image), 9, 8), 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if pix[y][x+1] > pix[y][x] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// DHashVertical computes a 64-bit vertical difference hash.
+// It is the transposed variant of DHash: the image is resized to 8x9
+// and every pixel is compared with the one below it.
+func DHashVertical(image image.Image) uint64 {
+	if image == nil {
+		return 0
+	}
+	pix := grayMatrix(Resize(Grayscale(image), 8, 9), 8, 9)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if pix[y+1][x] > pix[y][x] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// DHash128 computes the combined 128-bit difference hash.
+// Index 0 holds the horizontal DHash, index 1 the DHashVertical.
+func DHash128(image image.Image) [2]uint64 {
+	return [2]uint64{DHash(image), DHashVertical(image)}
+}
+
+// HammingDistance128 returns the number of differing bits between two 128-bit hashes.
+func HammingDistance128(a, b [2]uint64) int {
+	return HammingDistance(a[0], b[0]) + HammingDistance(a[1], b[1])
+}
@@ -0,0 +1,45 @@
+package phash
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDHashGoldenValues(t *testing.T) {
+	testCases := []struct {
+		path       string
+		horizontal uint64
+		vertical   uint64
+	}{
+		{path: "sweater-thumb.jpg", horizontal: 0x3a33330f31332f33, vertical: 0x8181e73c0000ffff},
+		{path: "sweater-medium.jpg", horizontal: 0x3a33330f31332f33, vertical: 0x8181e73c0000ffff},
+		{path: "sweater-large.jpg", horizontal: 0x3a33330f31332f33, vertical: 0x8181e73c0000ffff},
+		{path: "tblue.jpeg", horizontal: 0x20c4071796866000, vertical: 0x6681816e663c3c00},
+		{path: "tgray.jpeg", horizontal: 0x28c4171796866800, vertical: 0x6681816e663c3c00},
+		{path: "kblue.webp", horizontal: 0x4c544c4c8e4e2c20, vertical: 0x02243442023c3c18},
+		{path: "kyellow.jpeg", horizontal: 0x4c564c4c8e8e6c2c, vertical: 0x00243443423c3c18},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.path, func(t *testing.T) {
+			img := decodeTestImage(t, filepath.Join("test_data", tc.path))
+			if got := DHash(img); got != tc.horizontal {
+				t.Fatalf("unexpected dHash: got %016x want %016x", got, tc.horizontal)
+			}
+			if got := DHashVertical(img); got != tc.vertical {
+				t.Fatalf("unexpected vertical dHash: got %016x want %016x", got, tc.vertical)
+			}
+			want := [2]uint64{tc.horizontal, tc.vertical}
+			if got := DHash128(img); got != want {
+				t.Fatalf("unexpected 128-bit dHash: got %016x want %016x", got, want)
+			}
+		})
+	}
+}
+
+func TestDHashNil(t *testing.T) {
+	if got := DHash128(nil); got != [2]uint64{} {
+		t.Fatalf("DHash128(nil) = %016x, want zero", got)
+	}
+}
@@ -0,0 +1,200 @@
+package phash
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry defaults used when the RetryPolicy fields are zero.
+const (
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 30 * time.Second
+)
+
+// RetryPolicy controls retries of downloads that fail with HTTP 429 or 5xx.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values <= 1 disable retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles for every further retry.
+	// Zero means 500ms.
+	BaseDelay time.Duration
+	// MaxDelay caps both the backoff and a server's Retry-After. Zero means 30s.
+	MaxDelay time.Duration
+}
+
+// DownloadOptions configures DownloadAndDecode.
+type DownloadOptions struct {
+	// DecodeOptions applies to the response body: byte cap, dimension limits,
+	// accepted formats, orientation and downscaling.
+	DecodeOptions
+
+	// Client sends the requests; nil uses http.DefaultClient.
+	// Use a custom Transport for proxies, TLS settings or connection limits.
+	Client *http.Client
+	// Header holds extra request headers, e.g. Authorization or signed CDN headers.
+	Header http.Header
+	// UserAgent, if set, overrides the User-Agent header.
+	UserAgent string
+	// Timeout bounds the whole download including retries; zero means no timeout
+	// beyond ctx and the client's own.
+	Timeout time.Duration
+	// AllowedContentTypes lists accepted media types, e.g. "image/jpeg" or "image/*".
+	// Empty accepts any Content-Type.
+	AllowedContentTypes []string
+	// Retry configures retries on HTTP 429 and 5xx responses.
+	Retry RetryPolicy
+}
+
+// DownloadAndDecode fetches a remote image over HTTP with opts and decodes it like Decode.
+// Errors are returned as DecodeError with Op "request", "http", "http status", "content type", "read",
+// "format", "limits", or "decode".
+func DownloadAndDecode(ctx context.Context, url string, opts DownloadOptions) (image.Image, string, error) {
+	b, err := Download(ctx, url, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return decodeBytes(b, opts.DecodeOptions)
+}
+
+// Download fetches the body of url with the same request, retry and size checks as
+// DownloadAndDecode, without decoding it. Of the embedded DecodeOptions only MaxBytes applies.
+// It lets callers keep slow network transfers apart from CPU-bound decoding.
+// Errors are returned as DecodeError with Op "request", "http", "http status", "content type", or "read".
+func Download(ctx context.Context, url string, opts DownloadOptions) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, DecodeError{Op: DecodeOpRequest, Err: err}
+		}
+		req.Header.Set("Accept", "image/*,*/*;q=0.8")
+		for k, v := range opts.Header {
+			req.Header[k] = v
+		}
+		if opts.UserAgent != "" {
+			req.Header.Set("User-Agent", opts.UserAgent)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, DecodeError{Op: DecodeOpHTTP, Err: err}
+		}
+
+		if retryableStatus(resp.StatusCode) && attempt < opts.Retry.MaxAttempts {
+			delay := opts.Retry.delay(attempt, resp.Header.Get("Retry-After"))
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, DecodeError{Op: DecodeOpHTTP, Err: err}
+			}
+			continue
+		}
+
+		b, err := readResponse(resp, opts)
+		resp.Body.Close()
+		return b, err
+	}
+}
+
+// readResponse checks the status, Content-Type and Content-Length of resp and reads its body.
+func readResponse(resp *http.Response, opts DownloadOptions) ([]byte, error) {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, DecodeError{Op: DecodeOpHTTPStatus, Err: HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status, URL: resp.Request.URL.String()}}
+	}
+	if !contentTypeAllowed(resp.Header.Get("Content-Type"), opts.AllowedContentTypes) {
+		return nil, DecodeError{Op: DecodeOpContentType, Err: fmt.Errorf("%q not allowed", resp.Header.Get("Content-Type"))}
+	}
+	if opts.MaxBytes > 0 && resp.ContentLength > opts.MaxBytes {
+		return nil, DecodeError{Op: DecodeOpRead, Err: fmt.Errorf("%w: Content-Length %d exceeds %d bytes", ErrTooLarge, resp.ContentLength, opts.MaxBytes)}
+	}
+	b, err := readAll(resp.Body, opts.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	// The transport already reports short bodies as io.ErrUnexpectedEOF; this also
+	// catches clients or proxies that do not enforce Content-Length.
+	if resp.ContentLength >= 0 && int64(len(b)) != resp.ContentLength {
+		return nil, DecodeError{Op: DecodeOpRead, Err: fmt.Errorf("%w: got %d of %d bytes", ErrTruncated, len(b), resp.ContentLength)}
+	}
+	return b, nil
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// delay returns the wait before retry number attempt (1-based): the server's
+// Retry-After (seconds or HTTP date) if present, otherwise exponential backoff,
+// capped at MaxDelay either way.
+func (p RetryPolicy) delay(attempt int, retryAfter string) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	d := base << (attempt - 1)
+	if d <= 0 || d > maxDelay { // overflow or above cap
+		d = maxDelay
+	}
+	if retryAfter != "" {
+		if secs, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil && secs >= 0 {
+			d = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(retryAfter); err == nil {
+			d = max(time.Until(t), 0)
+		}
+	}
+	return min(d, maxDelay)
+}
+
+// contentTypeAllowed reports whether the media type of contentType matches one of allowed.
+// Entries ending in "/*" match any subtype.
+func contentTypeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mediaType || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, a[:len(a)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,225 @@
+package phash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 4, 3))
+	img.SetGray(1, 1, color.Gray{Y: 200})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadAndDecodeHeaders(t *testing.T) {
+	body := testPNG(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Signature") != "abc" || r.Header.Get("User-Agent") != "catalogue/1.0" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{
+		Client:              srv.Client(),
+		Header:              http.Header{"X-Signature": {"abc"}},
+		UserAgent:           "catalogue/1.0",
+		AllowedContentTypes: []string{"image/*"},
+	}
+	img, format, err := DownloadAndDecode(context.Background(), srv.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || img.Bounds().Dx() != 4 || img.Bounds().Dy() != 3 {
+		t.Fatalf("got %s %v, want png 4x3", format, img.Bounds())
+	}
+
+	opts.Header = nil
+	_, _, err = DownloadAndDecode(context.Background(), srv.URL, opts)
+	var de DecodeError
+	if !errors.As(err, &de) || de.Op != DecodeOpHTTPStatus {
+		t.Fatalf("without signature: err = %v, want %q error", err, DecodeOpHTTPStatus)
+	}
+}
+
+func TestDownloadAndDecodeRetry(t *testing.T) {
+	body := testPNG(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case 2:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{Client: srv.Client(), Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+	if _, _, err := DownloadAndDecode(context.Background(), srv.URL, opts); err != nil {
+		t.Fatalf("err = %v after %d calls", err, calls.Load())
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("server called %d times, want 3", calls.Load())
+	}
+
+	calls.Store(0)
+	opts.Retry.MaxAttempts = 2
+	_, _, err := DownloadAndDecode(context.Background(), srv.URL, opts)
+	var de DecodeError
+	if !errors.As(err, &de) || de.Op != DecodeOpHTTPStatus {
+		t.Fatalf("err = %v, want %q error once attempts run out", err, DecodeOpHTTPStatus)
+	}
+}
+
+func TestDownloadAndDecodeContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>login</html>"))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{Client: srv.Client(), AllowedContentTypes: []string{"image/jpeg", "image/png"}}
+	_, _, err := DownloadAndDecode(context.Background(), srv.URL, opts)
+	var de DecodeError
+	if !errors.As(err, &de) || de.Op != DecodeOpContentType {
+		t.Fatalf("err = %v, want %q error", err, DecodeOpContentType)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for _, tc := range []struct {
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{1, "", 100 * time.Millisecond},
+		{3, "", 400 * time.Millisecond},
+		{10, "", time.Second},
+		{1, "0", 0},
+		{1, "120", time.Second},
+		{1, "Mon, 02 Jan 2006 15:04:05 GMT", 0}, // in the past
+		{2, "soon", 200 * time.Millisecond},
+	} {
+		if got := p.delay(tc.attempt, tc.retryAfter); got != tc.want {
+			t.Fatalf("delay(%d, %q) = %v, want %v", tc.attempt, tc.retryAfter, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadErrorsUnwrap(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, _, err := DownloadAndDecodeAny(context.Background(), srv.URL+"/missing.jpg")
+	var se HTTPStatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound || se.URL != srv.URL+"/missing.jpg" {
+		t.Fatalf("err = %v, want HTTPStatusError 404 for the URL", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = DownloadAndDecode(ctx, srv.URL, DownloadOptions{Client: srv.Client()})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestDownloadHelpersSendAccept(t *testing.T) {
+	body := testPNG(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "" {
+			http.Error(w, "missing Accept", http.StatusNotAcceptable)
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	if _, _, err := DownloadAndDecodeAny(context.Background(), srv.URL); err != nil {
+		t.Fatalf("DownloadAndDecodeAny: %v", err)
+	}
+	if _, _, err := DownloadAndDecodeAnyWithLimit(context.Background(), srv.URL, 1<<20); err != nil {
+		t.Fatalf("DownloadAndDecodeAnyWithLimit: %v", err)
+	}
+}
+
+func TestDownloadAndDecodeSizeChecks(t *testing.T) {
+	body := testPNG(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/announced":
+			w.Header().Set("Content-Length", "100000000")
+			w.Write(body)
+		case "/short":
+			// Declare more than is sent; the server closes the connection early.
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)+100))
+			w.Write(body)
+		default:
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path     string
+		maxBytes int64
+		want     error
+	}{
+		{"/", int64(len(body)) - 1, ErrTooLarge},
+		{"/announced", 1 << 20, ErrTooLarge},
+		{"/short", 0, ErrTruncated},
+		{"/", int64(len(body)), nil},
+	} {
+		opts := DownloadOptions{Client: srv.Client(), DecodeOptions: DecodeOptions{MaxBytes: tc.maxBytes}}
+		_, _, err := DownloadAndDecode(context.Background(), srv.URL+tc.path, opts)
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("%s with MaxBytes %d: err = %v, want %v", tc.path, tc.maxBytes, err, tc.want)
+		}
+	}
+}
+
+func TestReadResponseContentLengthMismatch(t *testing.T) {
+	body := testPNG(t)
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)) + 10,
+	}
+	if _, err := readResponse(resp, DownloadOptions{}); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("err = %v, want %v", err, ErrTruncated)
+	}
+}
+
+func TestDownloadAndDecodeAnyWithLimitRejectsNonPositive(t *testing.T) {
+	for _, maxBytes := range []int64{0, -1} {
+		_, _, err := DownloadAndDecodeAnyWithLimit(context.Background(), "http://example.invalid/a.jpg", maxBytes)
+		var de DecodeError
+		if !errors.As(err, &de) || de.Op != DecodeOpRequest {
+			t.Fatalf("maxBytes %d: err = %v, want %q error", maxBytes, err, DecodeOpRequest)
+		}
+	}
+}
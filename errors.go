@@ -1,15 +1,50 @@
 package phash
 
-// DecodeError describes failures in HTTP setup, HTTP status, IO reads, or image decoding.
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Errors returned by hash functions that take size parameters.
+var (
+	ErrInvalidHashSize       = errors.New("phash: hash size must be 8, 16 or 32")
+	ErrInvalidHighFreqFactor = errors.New("phash: high-frequency factor must be between 1 and 8")
+)
+
+// Errors returned when constructing, parsing, or comparing Hash values.
+var (
+	ErrUnknownAlgorithm = errors.New("phash: unknown hash algorithm")
+	ErrInvalidHash      = errors.New("phash: invalid hash")
+	ErrHashMismatch     = errors.New("phash: hashes are not comparable")
+)
+
+// Errors returned when reading index files.
+var (
+	ErrIndexFormat   = errors.New("phash: invalid index file")
+	ErrIndexChecksum = errors.New("phash: index file checksum mismatch")
+)
+
+// Errors wrapped by DecodeError, for use with errors.Is.
+var (
+	ErrUnknownFormat = errors.New("phash: unknown image format")
+	ErrTruncated     = errors.New("phash: truncated image data")
+	ErrTooLarge      = errors.New("phash: image too large")
+)
+
+// DecodeError describes failures in HTTP setup, HTTP status, IO reads, size limits, or image decoding.
 // Returned by the helpers in decode.go to avoid raw fmt.Errorf strings.
 type DecodeOp string
 
 const (
-	DecodeOpRequest    DecodeOp = "request"
-	DecodeOpHTTP       DecodeOp = "http"
-	DecodeOpHTTPStatus DecodeOp = "http status"
-	DecodeOpRead       DecodeOp = "read"
-	DecodeOpDecode     DecodeOp = "decode"
+	DecodeOpRequest     DecodeOp = "request"
+	DecodeOpHTTP        DecodeOp = "http"
+	DecodeOpHTTPStatus  DecodeOp = "http status"
+	DecodeOpContentType DecodeOp = "content type"
+	DecodeOpRead        DecodeOp = "read"
+	DecodeOpFormat      DecodeOp = "format"
+	DecodeOpLimits      DecodeOp = "limits"
+	DecodeOpDecode      DecodeOp = "decode"
 )
 
 type DecodeError struct {
@@ -25,6 +60,25 @@ func (e DecodeError) Error() string {
 	return string(e.Op) + ": " + e.Err.Error()
 }
 
+// Unwrap returns the underlying error, so errors.Is and errors.As see through DecodeError.
+func (e DecodeError) Unwrap() error { return e.Err }
+
+// HTTPStatusError reports a non-2xx HTTP response. It is wrapped in a DecodeError with Op "http status".
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string // e.g. "404 Not Found"
+	URL        string
+}
+
+// Error formats HTTPStatusError as "url: status".
+func (e HTTPStatusError) Error() string {
+	status := e.Status
+	if status == "" {
+		status = strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+	}
+	return e.URL + ": " + status
+}
+
 // EncodeError describes failures when encoding images.
 // Returned by helpers in encode.go to avoid raw fmt.Errorf strings.
 type EncodeOp string
@@ -0,0 +1,287 @@
+package phash
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/bits"
+	"strings"
+)
+
+// Algorithm names the hash function that produced a Hash.
+type Algorithm string
+
+const (
+	AlgorithmPHash         Algorithm = "phash"
+	AlgorithmAHash         Algorithm = "ahash"
+	AlgorithmMedianHash    Algorithm = "mhash"
+	AlgorithmWHash         Algorithm = "whash"
+	AlgorithmDHash         Algorithm = "dhash"
+	AlgorithmDHashVertical Algorithm = "dhash-v"
+	AlgorithmDHash128      Algorithm = "dhash128"
+)
+
+// algorithmInfo lists the binary code and the valid bit lengths of each algorithm.
+// Codes are part of the binary encoding and must never be reused.
+var algorithmInfo = map[Algorithm]struct {
+	code byte
+	bits []int
+}{
+	AlgorithmPHash:         {code: 1, bits: []int{64, 256, 1024}},
+	AlgorithmAHash:         {code: 2, bits: []int{64}},
+	AlgorithmMedianHash:    {code: 3, bits: []int{64}},
+	AlgorithmWHash:         {code: 4, bits: []int{64}},
+	AlgorithmDHash:         {code: 5, bits: []int{64}},
+	AlgorithmDHashVertical: {code: 6, bits: []int{64}},
+	AlgorithmDHash128:      {code: 7, bits: []int{128}},
+}
+
+// hashBinaryVersion is the first byte of the MarshalBinary encoding.
+const hashBinaryVersion = 1
+
+// Hash is an image hash tagged with the algorithm that produced it.
+// Bits are stored in 64-bit words, MSB-first, in the same layout as PHash and WideHash.
+//
+// The zero Hash has no algorithm and no bits; it formats as "" and marshals to JSON null.
+type Hash struct {
+	algo  Algorithm
+	words []uint64
+}
+
+// NewHash returns a Hash for algo made of the given 64-bit words.
+// It returns an error if the algorithm is unknown or does not produce hashes of that length.
+func NewHash(algo Algorithm, words ...uint64) (Hash, error) {
+	if err := checkHashShape(algo, len(words)*64); err != nil {
+		return Hash{}, err
+	}
+	return Hash{algo: algo, words: append([]uint64(nil), words...)}, nil
+}
+
+// HashImage computes the hash of img with algo.
+// pHash is computed at 64 bits; use PHashSize and NewHash for larger pHashes.
+func HashImage(img image.Image, algo Algorithm) (Hash, error) {
+	var words []uint64
+	switch algo {
+	case AlgorithmPHash:
+		words = []uint64{PHash(img)}
+	case AlgorithmAHash:
+		words = []uint64{AHash(img)}
+	case AlgorithmMedianHash:
+		words = []uint64{MedianHash(img)}
+	case AlgorithmWHash:
+		words = []uint64{WHash(img)}
+	case AlgorithmDHash:
+		words = []uint64{DHash(img)}
+	case AlgorithmDHashVertical:
+		words = []uint64{DHashVertical(img)}
+	case AlgorithmDHash128:
+		d := DHash128(img)
+		words = d[:]
+	default:
+		return Hash{}, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algo)
+	}
+	return Hash{algo: algo, words: words}, nil
+}
+
+// ParseHash parses the text form produced by Hash.String ("algo:hex").
+// A bare hex string without an algorithm prefix is read as a pHash, so the
+// "%016x" output of earlier versions and the CLI can be parsed as well.
+func ParseHash(s string) (Hash, error) {
+	algo := AlgorithmPHash
+	digits := s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		algo, digits = Algorithm(s[:i]), s[i+1:]
+	}
+	if len(digits) == 0 || len(digits)%16 != 0 {
+		return Hash{}, fmt.Errorf("%w: %q", ErrInvalidHash, s)
+	}
+	raw, err := hex.DecodeString(digits)
+	if err != nil {
+		return Hash{}, fmt.Errorf("%w: %q", ErrInvalidHash, s)
+	}
+	words := make([]uint64, len(raw)/8)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint64(raw[i*8:])
+	}
+	return NewHash(algo, words...)
+}
+
+// Algorithm returns the algorithm that produced h.
+func (h Hash) Algorithm() Algorithm { return h.algo }
+
+// Bits returns the hash length in bits.
+func (h Hash) Bits() int { return len(h.words) * 64 }
+
+// IsZero reports whether h is the zero Hash.
+func (h Hash) IsZero() bool { return h.algo == "" && len(h.words) == 0 }
+
+// Words returns a copy of the 64-bit words of h, MSB-first.
+func (h Hash) Words() []uint64 { return append([]uint64(nil), h.words...) }
+
+// Uint64 returns the first 64 bits of h (the whole hash for 64-bit algorithms).
+func (h Hash) Uint64() uint64 {
+	if len(h.words) == 0 {
+		return 0
+	}
+	return h.words[0]
+}
+
+// Equal reports whether h and o have the same algorithm and bits.
+func (h Hash) Equal(o Hash) bool {
+	if h.algo != o.algo || len(h.words) != len(o.words) {
+		return false
+	}
+	for i := range h.words {
+		if h.words[i] != o.words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Distance returns the Hamming distance between h and o.
+// It returns ErrHashMismatch if the hashes come from different algorithms or have different lengths.
+func (h Hash) Distance(o Hash) (int, error) {
+	if h.algo != o.algo || len(h.words) != len(o.words) {
+		return 0, fmt.Errorf("%w: %s/%d vs %s/%d", ErrHashMismatch, h.algo, h.Bits(), o.algo, o.Bits())
+	}
+	d := 0
+	for i := range h.words {
+		d += bits.OnesCount64(h.words[i] ^ o.words[i])
+	}
+	return d, nil
+}
+
+// Hex returns the bits of h as lowercase hex without the algorithm prefix
+// (16 hex digits per 64 bits, same as "%016x" for a 64-bit hash).
+func (h Hash) Hex() string {
+	var sb strings.Builder
+	sb.Grow(len(h.words) * 16)
+	for _, w := range h.words {
+		fmt.Fprintf(&sb, "%016x", w)
+	}
+	return sb.String()
+}
+
+// String formats h as "algo:hex", e.g. "phash:fa85955a872769cb".
+// The zero Hash formats as "".
+func (h Hash) String() string {
+	if h.IsZero() {
+		return ""
+	}
+	return string(h.algo) + ":" + h.Hex()
+}
+
+// MarshalText implements encoding.TextMarshaler using the String format.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler using ParseHash.
+// Empty input yields the zero Hash.
+func (h *Hash) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*h = Hash{}
+		return nil
+	}
+	parsed, err := ParseHash(string(text))
+	if err != nil {
+		return err
+	}
+	*h = parsed
+	return nil
+}
+
+// MarshalJSON encodes h as a JSON string in the String format, or null for the zero Hash.
+func (h Hash) MarshalJSON() ([]byte, error) {
+	if h.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + h.String() + `"`), nil
+}
+
+// UnmarshalJSON decodes a JSON string in the String format; null yields the zero Hash.
+func (h *Hash) UnmarshalJSON(data []byte) error {
+	var s string // null leaves s empty, which UnmarshalText turns into the zero Hash
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%w: JSON value must be a string: %w", ErrInvalidHash, err)
+	}
+	return h.UnmarshalText([]byte(s))
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// Layout: version (1 byte), algorithm code (1 byte), bit length (uint16 BE),
+// then the words as uint64 BE.
+func (h Hash) MarshalBinary() ([]byte, error) {
+	if h.IsZero() {
+		return nil, nil
+	}
+	info, ok := algorithmInfo[h.algo]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, h.algo)
+	}
+	out := make([]byte, 4, 4+len(h.words)*8)
+	out[0] = hashBinaryVersion
+	out[1] = info.code
+	binary.BigEndian.PutUint16(out[2:4], uint16(h.Bits()))
+	for _, w := range h.words {
+		out = binary.BigEndian.AppendUint64(out, w)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// Empty input yields the zero Hash.
+func (h *Hash) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		*h = Hash{}
+		return nil
+	}
+	if len(data) < 4 || data[0] != hashBinaryVersion {
+		return fmt.Errorf("%w: unsupported binary encoding", ErrInvalidHash)
+	}
+	algo, ok := algorithmByCode(data[1])
+	if !ok {
+		return fmt.Errorf("%w: code %d", ErrUnknownAlgorithm, data[1])
+	}
+	n := int(binary.BigEndian.Uint16(data[2:4]))
+	if n%64 != 0 || len(data) != 4+n/8 {
+		return fmt.Errorf("%w: binary length does not match %d bits", ErrInvalidHash, n)
+	}
+	words := make([]uint64, n/64)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint64(data[4+i*8:])
+	}
+	parsed, err := NewHash(algo, words...)
+	if err != nil {
+		return err
+	}
+	*h = parsed
+	return nil
+}
+
+// checkHashShape validates that algo is known and produces hashes of n bits.
+func checkHashShape(algo Algorithm, n int) error {
+	info, ok := algorithmInfo[algo]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algo)
+	}
+	for _, b := range info.bits {
+		if b == n {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s does not produce %d-bit hashes", ErrInvalidHash, algo, n)
+}
+
+func algorithmByCode(code byte) (Algorithm, bool) {
+	for algo, info := range algorithmInfo {
+		if info.code == code {
+			return algo, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,110 @@
+package phash
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashTextRoundTrip(t *testing.T) {
+	h, err := HashImage(decodeTestImage(t, filepath.Join("test_data", "sweater-thumb.jpg")), AlgorithmPHash)
+	if err != nil {
+		t.Fatalf("HashImage: %v", err)
+	}
+	if got, want := h.String(), "phash:fa85955a872769cb"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+
+	for _, text := range []string{"phash:fa85955a872769cb", "fa85955a872769cb"} {
+		parsed, err := ParseHash(text)
+		if err != nil {
+			t.Fatalf("ParseHash(%q): %v", text, err)
+		}
+		if !parsed.Equal(h) {
+			t.Fatalf("ParseHash(%q) = %v, want %v", text, parsed, h)
+		}
+	}
+}
+
+func TestHashJSONAndBinaryRoundTrip(t *testing.T) {
+	hashes := []Hash{
+		mustNewHash(t, AlgorithmPHash, 0xfa85955a872769cb),
+		mustNewHash(t, AlgorithmDHash128, 0x3a33330f31332f33, 0x8181e73c0000ffff),
+		mustNewHash(t, AlgorithmPHash, 1, 2, 3, 4),
+		{},
+	}
+	for _, h := range hashes {
+		type record struct {
+			Hash Hash `json:"hash"`
+		}
+		data, err := json.Marshal(record{Hash: h})
+		if err != nil {
+			t.Fatalf("json.Marshal(%v): %v", h, err)
+		}
+		var decoded record
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("json.Unmarshal(%s): %v", data, err)
+		}
+		if !decoded.Hash.Equal(h) {
+			t.Fatalf("JSON round trip: got %v want %v", decoded.Hash, h)
+		}
+
+		bin, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary(%v): %v", h, err)
+		}
+		var fromBin Hash
+		if err := fromBin.UnmarshalBinary(bin); err != nil {
+			t.Fatalf("UnmarshalBinary(%x): %v", bin, err)
+		}
+		if !fromBin.Equal(h) {
+			t.Fatalf("binary round trip: got %v want %v", fromBin, h)
+		}
+	}
+}
+
+func TestHashUnmarshalJSONEscapes(t *testing.T) {
+	var h Hash
+	if err := json.Unmarshal([]byte(`"phash:\u0066a85955a872769cb"`), &h); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if want := mustNewHash(t, AlgorithmPHash, 0xfa85955a872769cb); !h.Equal(want) {
+		t.Fatalf("got %v want %v", h, want)
+	}
+	if err := json.Unmarshal([]byte(`42`), &h); !errors.Is(err, ErrInvalidHash) {
+		t.Fatalf("json.Unmarshal(42) error = %v, want ErrInvalidHash", err)
+	}
+}
+
+func TestHashRejectsInvalidInput(t *testing.T) {
+	testCases := []struct {
+		text string
+		want error
+	}{
+		{text: "xhash:fa85955a872769cb", want: ErrUnknownAlgorithm},
+		{text: "ahash:fa85955a872769cbfa85955a872769cb", want: ErrInvalidHash},
+		{text: "fa85955a87", want: ErrInvalidHash},
+		{text: "zz85955a872769cb", want: ErrInvalidHash},
+	}
+	for _, tc := range testCases {
+		if _, err := ParseHash(tc.text); !errors.Is(err, tc.want) {
+			t.Fatalf("ParseHash(%q): got %v want %v", tc.text, err, tc.want)
+		}
+	}
+
+	p := mustNewHash(t, AlgorithmPHash, 0)
+	a := mustNewHash(t, AlgorithmAHash, 0)
+	if _, err := p.Distance(a); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Distance across algorithms: got %v want %v", err, ErrHashMismatch)
+	}
+}
+
+func mustNewHash(t *testing.T, algo Algorithm, words ...uint64) Hash {
+	t.Helper()
+	h, err := NewHash(algo, words...)
+	if err != nil {
+		t.Fatalf("NewHash(%s): %v", algo, err)
+	}
+	return h
+}
@@ -0,0 +1,261 @@
+package phash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Entry is an (id, hash) pair stored in an index.
+type Entry struct {
+	ID   string
+	Hash uint64
+}
+
+// Index is a searchable collection of 64-bit hashes. BKTree and MultiIndex implement it.
+type Index interface {
+	Add(hash uint64, id string)
+	Remove(id string) int
+	Search(hash uint64, maxDist int) []Match
+	Len() int
+	Entries() []Entry
+}
+
+// IndexKind names the search structure stored in an index file.
+type IndexKind uint8
+
+const (
+	IndexKindBKTree     IndexKind = 1
+	IndexKindMultiIndex IndexKind = 2
+)
+
+// IndexHeader describes the contents of an index file.
+type IndexHeader struct {
+	Algorithm  Algorithm // hash algorithm of every entry
+	Bits       int       // hash length in bits; only 64 is supported
+	Kind       IndexKind
+	Substrings int // MultiIndex substring count; 0 for BKTree
+}
+
+// Index file layout (version 1), all integers big-endian:
+//
+//	header: "PHIX" | version u8 | algo length u8 | algo | bits u16 | kind u8 | substrings u8 | crc32c u32
+//	record: id length uvarint | id | hash (bits/8 bytes) | crc32c u32
+//
+// Each checksum covers the bytes of its own header or record, so new records can
+// be appended to an existing file (see AppendIndexEntries) without rewriting it.
+const indexFileVersion = 1
+
+var (
+	indexFileMagic = []byte("PHIX")
+	indexCRCTable  = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// maxIndexIDLen caps record id lengths to keep corrupt files from causing huge allocations.
+const maxIndexIDLen = 1 << 20
+
+// SaveIndex writes the header and every entry of idx to w.
+func SaveIndex(w io.Writer, algo Algorithm, idx Index) error {
+	hdr := IndexHeader{Algorithm: algo, Bits: 64}
+	switch v := idx.(type) {
+	case *BKTree:
+		hdr.Kind = IndexKindBKTree
+	case *MultiIndex:
+		hdr.Kind = IndexKindMultiIndex
+		hdr.Substrings = v.Substrings()
+	default:
+		return fmt.Errorf("%w: unsupported index type %T", ErrIndexFormat, idx)
+	}
+	if err := checkHashShape(algo, hdr.Bits); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeIndexHeader(bw, hdr); err != nil {
+		return err
+	}
+	if err := writeIndexRecords(bw, idx.Entries()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// AppendIndexEntries writes entries as records only, for appending to an existing
+// index file (open it with os.O_APPEND). The entries must use the file's algorithm.
+func AppendIndexEntries(w io.Writer, entries ...Entry) error {
+	bw := bufio.NewWriter(w)
+	if err := writeIndexRecords(bw, entries); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadIndex reads an index file and rebuilds the search structure named in its header.
+// Any checksum mismatch or truncated record fails the whole load.
+func LoadIndex(r io.Reader) (Index, IndexHeader, error) {
+	br := bufio.NewReader(r)
+	hdr, err := readIndexHeader(br)
+	if err != nil {
+		return nil, IndexHeader{}, err
+	}
+
+	var idx Index
+	switch hdr.Kind {
+	case IndexKindBKTree:
+		idx = NewBKTree()
+	case IndexKindMultiIndex:
+		idx = NewMultiIndex(hdr.Substrings)
+	}
+
+	for {
+		e, err := readIndexRecord(br)
+		if err == io.EOF {
+			return idx, hdr, nil
+		}
+		if err != nil {
+			return nil, IndexHeader{}, err
+		}
+		idx.Add(e.Hash, e.ID)
+	}
+}
+
+// SaveIndexFile writes idx to path atomically (temporary file + fsync + rename).
+// The file is created with mode 0644.
+func SaveIndexFile(path string, algo Algorithm, idx Index) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// CreateTemp uses 0600; match the permissions os.Create would give.
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := SaveIndex(f, algo, idx); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	// Flush to disk before the rename so a crash cannot leave an empty file at path.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadIndexFile reads the index file at path.
+func LoadIndexFile(path string) (Index, IndexHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, IndexHeader{}, err
+	}
+	defer f.Close()
+	return LoadIndex(f)
+}
+
+func writeIndexHeader(w io.Writer, hdr IndexHeader) error {
+	b := append([]byte(nil), indexFileMagic...)
+	b = append(b, indexFileVersion, byte(len(hdr.Algorithm)))
+	b = append(b, hdr.Algorithm...)
+	b = binary.BigEndian.AppendUint16(b, uint16(hdr.Bits))
+	b = append(b, byte(hdr.Kind), byte(hdr.Substrings))
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, indexCRCTable))
+	_, err := w.Write(b)
+	return err
+}
+
+func readIndexHeader(r *bufio.Reader) (IndexHeader, error) {
+	var hdr IndexHeader
+	fixed := make([]byte, 6) // magic, version, algo length
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return hdr, fmt.Errorf("%w: header: %v", ErrIndexFormat, err)
+	}
+	if string(fixed[:4]) != string(indexFileMagic) {
+		return hdr, fmt.Errorf("%w: bad magic", ErrIndexFormat)
+	}
+	if fixed[4] != indexFileVersion {
+		return hdr, fmt.Errorf("%w: unsupported version %d", ErrIndexFormat, fixed[4])
+	}
+	rest := make([]byte, int(fixed[5])+4+4) // algo, bits, kind, substrings, crc
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return hdr, fmt.Errorf("%w: header: %v", ErrIndexFormat, err)
+	}
+	body := append(fixed, rest[:len(rest)-4]...)
+	if crc32.Checksum(body, indexCRCTable) != binary.BigEndian.Uint32(rest[len(rest)-4:]) {
+		return hdr, fmt.Errorf("%w: header", ErrIndexChecksum)
+	}
+
+	n := int(fixed[5])
+	hdr.Algorithm = Algorithm(rest[:n])
+	hdr.Bits = int(binary.BigEndian.Uint16(rest[n : n+2]))
+	hdr.Kind = IndexKind(rest[n+2])
+	hdr.Substrings = int(rest[n+3])
+	if err := checkHashShape(hdr.Algorithm, hdr.Bits); err != nil {
+		return hdr, err
+	}
+	if hdr.Bits != 64 {
+		return hdr, fmt.Errorf("%w: unsupported hash size %d", ErrIndexFormat, hdr.Bits)
+	}
+	if hdr.Kind != IndexKindBKTree && hdr.Kind != IndexKindMultiIndex {
+		return hdr, fmt.Errorf("%w: unknown index kind %d", ErrIndexFormat, hdr.Kind)
+	}
+	return hdr, nil
+}
+
+func writeIndexRecords(w io.Writer, entries []Entry) error {
+	var b []byte
+	for _, e := range entries {
+		b = binary.AppendUvarint(b[:0], uint64(len(e.ID)))
+		b = append(b, e.ID...)
+		b = binary.BigEndian.AppendUint64(b, e.Hash)
+		b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, indexCRCTable))
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readIndexRecord returns io.EOF only at a clean record boundary.
+func readIndexRecord(r *bufio.Reader) (Entry, error) {
+	if _, err := r.Peek(1); err == io.EOF {
+		return Entry{}, io.EOF
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: truncated record", ErrIndexFormat)
+	}
+	if n > maxIndexIDLen {
+		return Entry{}, fmt.Errorf("%w: id length %d", ErrIndexFormat, n)
+	}
+	b := binary.AppendUvarint(nil, n)
+	head := len(b)
+	b = append(b, make([]byte, int(n)+8+4)...)
+	if _, err := io.ReadFull(r, b[head:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return Entry{}, fmt.Errorf("%w: truncated record", ErrIndexFormat)
+		}
+		return Entry{}, err
+	}
+	end := len(b) - 4
+	if crc32.Checksum(b[:end], indexCRCTable) != binary.BigEndian.Uint32(b[end:]) {
+		return Entry{}, fmt.Errorf("%w: record", ErrIndexChecksum)
+	}
+	return Entry{
+		ID:   string(b[head : head+int(n)]),
+		Hash: binary.BigEndian.Uint64(b[head+int(n) : end]),
+	}, nil
+}
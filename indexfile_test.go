@@ -0,0 +1,110 @@
+package phash
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestIndexFileRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	hashes := clusteredHashes(rng, 500, 20)
+
+	for _, idx := range []Index{NewBKTree(), NewMultiIndex(5)} {
+		for i, h := range hashes {
+			idx.Add(h, "img-"+strconv.Itoa(i))
+		}
+
+		var buf bytes.Buffer
+		if err := SaveIndex(&buf, AlgorithmPHash, idx); err != nil {
+			t.Fatalf("SaveIndex(%T): %v", idx, err)
+		}
+		loaded, hdr, err := LoadIndex(&buf)
+		if err != nil {
+			t.Fatalf("LoadIndex(%T): %v", idx, err)
+		}
+		if reflect.TypeOf(loaded) != reflect.TypeOf(idx) {
+			t.Fatalf("loaded %T, want %T", loaded, idx)
+		}
+		if hdr.Algorithm != AlgorithmPHash || hdr.Bits != 64 {
+			t.Fatalf("unexpected header %+v", hdr)
+		}
+		if mi, ok := loaded.(*MultiIndex); ok && mi.Substrings() != 5 {
+			t.Fatalf("loaded MultiIndex has %d substrings, want 5", mi.Substrings())
+		}
+		if !reflect.DeepEqual(loaded.Entries(), idx.Entries()) {
+			t.Fatalf("%T entries differ after round trip", idx)
+		}
+		query := hashes[0]
+		if !reflect.DeepEqual(loaded.Search(query, 6), idx.Search(query, 6)) {
+			t.Fatalf("%T search results differ after round trip", idx)
+		}
+	}
+}
+
+func TestIndexFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.phix")
+	idx := NewMultiIndex(0)
+	idx.Add(0xfa85955a872769cb, "sweater")
+	if err := SaveIndexFile(path, AlgorithmPHash, idx); err != nil {
+		t.Fatalf("SaveIndexFile: %v", err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if runtime.GOOS != "windows" && st.Mode().Perm() != 0o644 {
+		t.Fatalf("saved file mode %v, want 0644", st.Mode().Perm())
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open for append: %v", err)
+	}
+	if err := AppendIndexEntries(f, Entry{ID: "tblue", Hash: 0xe1789e87385861e5}); err != nil {
+		t.Fatalf("AppendIndexEntries: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	loaded, _, err := LoadIndexFile(path)
+	if err != nil {
+		t.Fatalf("LoadIndexFile: %v", err)
+	}
+	want := []Entry{{ID: "sweater", Hash: 0xfa85955a872769cb}, {ID: "tblue", Hash: 0xe1789e87385861e5}}
+	if got := loaded.Entries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries after append = %v, want %v", got, want)
+	}
+}
+
+func TestIndexFileDetectsCorruption(t *testing.T) {
+	idx := NewBKTree()
+	idx.Add(1, "a")
+	idx.Add(2, "b")
+	var buf bytes.Buffer
+	if err := SaveIndex(&buf, AlgorithmPHash, idx); err != nil {
+		t.Fatalf("SaveIndex: %v", err)
+	}
+	data := buf.Bytes()
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)-6] ^= 0xff // inside the last record's hash
+	if _, _, err := LoadIndex(bytes.NewReader(flipped)); !errors.Is(err, ErrIndexChecksum) {
+		t.Fatalf("corrupt record: got %v want %v", err, ErrIndexChecksum)
+	}
+
+	if _, _, err := LoadIndex(bytes.NewReader(data[:len(data)-3])); !errors.Is(err, ErrIndexFormat) {
+		t.Fatalf("truncated record: got %v want %v", err, ErrIndexFormat)
+	}
+
+	if _, _, err := LoadIndex(bytes.NewReader([]byte("nope"))); !errors.Is(err, ErrIndexFormat) {
+		t.Fatalf("bad header: got %v want %v", err, ErrIndexFormat)
+	}
+}
@@ -0,0 +1,239 @@
+package phash
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// MultiIndex is a multi-index hashing (MIH) structure over 64-bit hashes.
+//
+// Each hash is split into m disjoint substrings and every substring is stored in
+// its own exact-match table. By the pigeonhole principle, any hash within
+// distance r of a query matches the query within floor(r/m) bits on at least one
+// substring, so probing the tables with small substring radii finds every match
+// (full recall) while only verifying a fraction of the entries with HammingDistance.
+// This scales much better than a BKTree for millions of hashes and small radii.
+//
+// A MultiIndex is not safe for concurrent use when Add is involved; guard it with
+// a lock if it is written while being searched.
+type MultiIndex struct {
+	offsets []uint // bit offset of each substring, counted from the LSB
+	widths  []uint // bit width of each substring
+	tables  []map[uint64][]uint32
+	hashes  []uint64
+	ids     []string
+	slots   map[string][]uint32 // live slots of each id
+	deleted []bool              // tombstones set by Remove; nil until the first removal
+	removed int
+}
+
+// DefaultMultiIndexSubstrings is the substring count used by NewMultiIndex when m <= 0.
+// Four 16-bit substrings suit collections of roughly 10^5..10^8 hashes.
+const DefaultMultiIndexSubstrings = 4
+
+// NewMultiIndex returns an empty MultiIndex splitting hashes into m substrings.
+// m <= 0 selects DefaultMultiIndexSubstrings; m > 64 is capped at 64.
+// When 64 is not divisible by m, the first substrings get one extra bit.
+func NewMultiIndex(m int) *MultiIndex {
+	if m <= 0 {
+		m = DefaultMultiIndexSubstrings
+	}
+	if m > 64 {
+		m = 64
+	}
+	idx := &MultiIndex{
+		offsets: make([]uint, m),
+		widths:  make([]uint, m),
+		tables:  make([]map[uint64][]uint32, m),
+		slots:   make(map[string][]uint32),
+	}
+	var off uint
+	for i := 0; i < m; i++ {
+		w := uint(64 / m)
+		if i < 64%m {
+			w++
+		}
+		idx.offsets[i], idx.widths[i] = off, w
+		idx.tables[i] = make(map[uint64][]uint32)
+		off += w
+	}
+	return idx
+}
+
+// Substrings returns the number of substrings m.
+func (idx *MultiIndex) Substrings() int { return len(idx.tables) }
+
+// Len returns the number of (hash, id) pairs in the index.
+func (idx *MultiIndex) Len() int { return len(idx.hashes) - idx.removed }
+
+// Add inserts a (hash, id) pair. Adding the same pair twice stores it twice.
+func (idx *MultiIndex) Add(hash uint64, id string) {
+	n := uint32(len(idx.hashes))
+	idx.hashes = append(idx.hashes, hash)
+	idx.ids = append(idx.ids, id)
+	idx.slots[id] = append(idx.slots[id], n)
+	if idx.deleted != nil {
+		idx.deleted = append(idx.deleted, false)
+	}
+	for i, table := range idx.tables {
+		key := idx.substring(hash, i)
+		table[key] = append(table[key], n)
+	}
+}
+
+// Remove deletes every pair with the given id and returns how many were removed.
+// It costs O(k) for k pairs with that id. Removed slots are dropped from the
+// substring tables and left as tombstones; once tombstones make up half of the
+// slots, the index is compacted.
+func (idx *MultiIndex) Remove(id string) int {
+	ns := idx.slots[id]
+	if len(ns) == 0 {
+		return 0
+	}
+	if idx.deleted == nil {
+		idx.deleted = make([]bool, len(idx.hashes))
+	}
+	for _, n := range ns {
+		idx.deleted[n] = true
+		for i, table := range idx.tables {
+			key := idx.substring(idx.hashes[n], i)
+			table[key] = slices.DeleteFunc(table[key], func(v uint32) bool { return v == n })
+			if len(table[key]) == 0 {
+				delete(table, key)
+			}
+		}
+	}
+	delete(idx.slots, id)
+	idx.removed += len(ns)
+	if idx.removed >= minCompactTombstones && idx.removed*2 >= len(idx.hashes) {
+		idx.compact()
+	}
+	return len(ns)
+}
+
+// minCompactTombstones keeps small indexes from being rebuilt on every Remove.
+const minCompactTombstones = 1024
+
+// compact rebuilds the index from its live pairs, dropping all tombstones.
+// Insertion order is preserved.
+func (idx *MultiIndex) compact() {
+	live := idx.Entries()
+	idx.hashes = make([]uint64, 0, len(live))
+	idx.ids = make([]string, 0, len(live))
+	idx.slots = make(map[string][]uint32, len(idx.slots))
+	idx.deleted, idx.removed = nil, 0
+	for i := range idx.tables {
+		idx.tables[i] = make(map[uint64][]uint32)
+	}
+	for _, e := range live {
+		idx.Add(e.Hash, e.ID)
+	}
+}
+
+// Entries returns every (id, hash) pair in insertion order.
+func (idx *MultiIndex) Entries() []Entry {
+	out := make([]Entry, 0, idx.Len())
+	for i, h := range idx.hashes {
+		if idx.deleted != nil && idx.deleted[i] {
+			continue
+		}
+		out = append(out, Entry{ID: idx.ids[i], Hash: h})
+	}
+	return out
+}
+
+// Search returns all entries within maxDist of hash, sorted by distance, then ID.
+func (idx *MultiIndex) Search(hash uint64, maxDist int) []Match {
+	var out []Match
+	if maxDist < 0 || len(idx.hashes) == 0 {
+		return out
+	}
+	m := len(idx.tables)
+	// Refined pigeonhole split: with maxDist = q*m + a, a match is within q bits on
+	// one of the first a+1 substrings, or within q-1 bits on one of the others.
+	q, a := maxDist/m, maxDist%m
+	radii := make([]int, m)
+	var probes uint64
+	for i := range radii {
+		radii[i] = q
+		if i > a {
+			radii[i] = q - 1
+		}
+		radii[i] = min(radii[i], int(idx.widths[i]))
+		probes += neighbourCount(idx.widths[i], radii[i])
+	}
+
+	// Large radii relative to the substring width make probing more expensive
+	// than checking every entry; fall back to a linear scan in that case.
+	if probes >= uint64(len(idx.hashes)) {
+		for n, h := range idx.hashes {
+			if idx.deleted != nil && idx.deleted[n] {
+				continue
+			}
+			if d := HammingDistance(hash, h); d <= maxDist {
+				out = append(out, Match{ID: idx.ids[n], Hash: h, Distance: d})
+			}
+		}
+		sortMatches(out)
+		return out
+	}
+
+	seen := make(map[uint32]struct{})
+	for i, table := range idx.tables {
+		if radii[i] < 0 {
+			continue
+		}
+		key := idx.substring(hash, i)
+		forEachNeighbour(key, idx.widths[i], radii[i], func(k uint64) {
+			for _, n := range table[k] {
+				if _, ok := seen[n]; ok {
+					continue
+				}
+				seen[n] = struct{}{}
+				if d := HammingDistance(hash, idx.hashes[n]); d <= maxDist {
+					out = append(out, Match{ID: idx.ids[n], Hash: idx.hashes[n], Distance: d})
+				}
+			}
+		})
+	}
+	sortMatches(out)
+	return out
+}
+
+func (idx *MultiIndex) substring(hash uint64, i int) uint64 {
+	return (hash >> idx.offsets[i]) & (1<<idx.widths[i] - 1)
+}
+
+// neighbourCount returns the number of width-bit keys within radius bits of a key,
+// saturating at math.MaxUint64.
+func neighbourCount(width uint, radius int) uint64 {
+	var total, c uint64 = 0, 1 // c = C(width, k)
+	for k := 0; k <= radius; k++ {
+		if total+c < total {
+			return math.MaxUint64
+		}
+		total += c
+		hi, lo := bits.Mul64(c, uint64(width)-uint64(k))
+		if hi != 0 {
+			return math.MaxUint64
+		}
+		c = lo / uint64(k+1)
+	}
+	return total
+}
+
+// forEachNeighbour calls fn for every width-bit key within radius bits of key.
+func forEachNeighbour(key uint64, width uint, radius int, fn func(uint64)) {
+	var flip func(k uint64, from uint, left int)
+	flip = func(k uint64, from uint, left int) {
+		fn(k)
+		if left == 0 {
+			return
+		}
+		for b := from; b < width; b++ {
+			flip(k^(1<<b), b+1, left-1)
+		}
+	}
+	flip(key, 0, radius)
+}
@@ -0,0 +1,196 @@
+package phash
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMultiIndexMatchesBKTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	hashes := clusteredHashes(rng, 5000, 50)
+
+	tree := NewBKTree()
+	for i, h := range hashes {
+		tree.Add(h, strconv.Itoa(i))
+	}
+
+	for _, m := range []int{1, 3, 4, 7, 64} {
+		idx := NewMultiIndex(m)
+		for i, h := range hashes {
+			idx.Add(h, strconv.Itoa(i))
+		}
+		for q := 0; q < 20; q++ {
+			query := hashes[rng.Intn(len(hashes))] ^ (1 << uint(rng.Intn(64)))
+			for _, radius := range []int{0, 2, 5, 9, 13} {
+				want := tree.Search(query, radius)
+				got := idx.Search(query, radius)
+				if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+					t.Fatalf("m=%d Search(%016x, %d): got %d matches want %d", m, query, radius, len(got), len(want))
+				}
+			}
+		}
+	}
+}
+
+func TestNewMultiIndexSubstrings(t *testing.T) {
+	for _, tc := range []struct{ m, want int }{{0, DefaultMultiIndexSubstrings}, {5, 5}, {100, 64}} {
+		idx := NewMultiIndex(tc.m)
+		if got := idx.Substrings(); got != tc.want {
+			t.Fatalf("NewMultiIndex(%d).Substrings() = %d, want %d", tc.m, got, tc.want)
+		}
+		var total uint
+		for _, w := range idx.widths {
+			total += w
+		}
+		if total != 64 {
+			t.Fatalf("NewMultiIndex(%d) covers %d bits, want 64", tc.m, total)
+		}
+	}
+}
+
+func BenchmarkMultiIndexSearch(b *testing.B) {
+	for _, n := range []int{1_000_000, 10_000_000} {
+		for _, kind := range []string{"random", "clustered"} {
+			b.Run(fmt.Sprintf("%s/n=%d", kind, n), func(b *testing.B) {
+				rng := rand.New(rand.NewSource(3))
+				var hashes []uint64
+				if kind == "random" {
+					hashes = make([]uint64, n)
+					for i := range hashes {
+						hashes[i] = rng.Uint64()
+					}
+				} else {
+					hashes = clusteredHashes(rng, n, n/100)
+				}
+				idx := NewMultiIndex(DefaultMultiIndexSubstrings)
+				for i, h := range hashes {
+					idx.Add(h, strconv.Itoa(i))
+				}
+
+				queries := make([]uint64, 1024)
+				for i := range queries {
+					queries[i] = hashes[rng.Intn(n)] ^ (1 << uint(rng.Intn(64)))
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					idx.Search(queries[i%len(queries)], 6)
+				}
+			})
+		}
+	}
+}
+
+// clusteredHashes returns n hashes grouped around the given number of random centres,
+// each within a few bits of its centre, like near-duplicate images in a catalogue.
+func clusteredHashes(rng *rand.Rand, n, clusters int) []uint64 {
+	centres := make([]uint64, clusters)
+	for i := range centres {
+		centres[i] = rng.Uint64()
+	}
+	out := make([]uint64, n)
+	for i := range out {
+		h := centres[rng.Intn(clusters)]
+		for f := rng.Intn(6); f > 0; f-- {
+			h ^= 1 << uint(rng.Intn(64))
+		}
+		out[i] = h
+	}
+	return out
+}
+
+func TestNeighbourCount(t *testing.T) {
+	for _, tc := range []struct {
+		width  uint
+		radius int
+		want   uint64
+	}{{16, -1, 0}, {16, 0, 1}, {16, 2, 1 + 16 + 120}, {4, 4, 16}, {64, 64, math.MaxUint64}} {
+		if got := neighbourCount(tc.width, tc.radius); got != tc.want {
+			t.Fatalf("neighbourCount(%d, %d) = %d, want %d", tc.width, tc.radius, got, tc.want)
+		}
+	}
+}
+
+func TestIndexRemove(t *testing.T) {
+	for _, idx := range []Index{NewBKTree(), NewMultiIndex(0)} {
+		idx.Add(0xf0, "a")
+		idx.Add(0xf1, "b")
+		idx.Add(0xf3, "a")
+		idx.Add(0xf0, "c")
+
+		if got := idx.Remove("a"); got != 2 {
+			t.Fatalf("%T Remove(a) = %d, want 2", idx, got)
+		}
+		if got := idx.Remove("a"); got != 0 {
+			t.Fatalf("%T second Remove(a) = %d, want 0", idx, got)
+		}
+		if idx.Len() != 2 {
+			t.Fatalf("%T Len() = %d, want 2", idx, idx.Len())
+		}
+		want := []Match{{ID: "c", Hash: 0xf0, Distance: 0}, {ID: "b", Hash: 0xf1, Distance: 1}}
+		if got := idx.Search(0xf0, 64); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%T Search after Remove = %v, want %v", idx, got, want)
+		}
+		if got := len(idx.Entries()); got != 2 {
+			t.Fatalf("%T Entries() has %d entries, want 2", idx, got)
+		}
+	}
+}
+
+func TestMultiIndexRemoveFromTables(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	idx := NewMultiIndex(0)
+	hashes := clusteredHashes(rng, 1000, 10)
+	for i, h := range hashes {
+		idx.Add(h, strconv.Itoa(i%500))
+	}
+	idx.Remove("7")
+	// Radius 0 probes the substring tables instead of scanning linearly.
+	for _, m := range idx.Search(hashes[7], 0) {
+		if m.ID == "7" {
+			t.Fatalf("removed id still found: %v", m)
+		}
+	}
+	if idx.Len() != 998 {
+		t.Fatalf("Len() = %d, want 998", idx.Len())
+	}
+}
+
+func TestMultiIndexReplaceCompacts(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	idx := NewMultiIndex(0)
+	hashes := clusteredHashes(rng, 3000, 30)
+	for i := 0; i < 1000; i++ {
+		idx.Add(hashes[i], strconv.Itoa(i))
+	}
+	// Replace every id twice, as the server does on repeated POST /index.
+	for i := 1000; i < 3000; i++ {
+		id := strconv.Itoa(i % 1000)
+		if got := idx.Remove(id); got != 1 {
+			t.Fatalf("Remove(%s) = %d, want 1", id, got)
+		}
+		idx.Add(hashes[i], id)
+	}
+
+	if idx.Len() != 1000 {
+		t.Fatalf("Len() = %d, want 1000", idx.Len())
+	}
+	if len(idx.hashes) > 1000+minCompactTombstones {
+		t.Fatalf("%d slots for 1000 live pairs: tombstones were not compacted", len(idx.hashes))
+	}
+	for i := 2000; i < 3000; i += 97 {
+		found := false
+		for _, m := range idx.Search(hashes[i], 0) {
+			found = found || m.ID == strconv.Itoa(i%1000)
+		}
+		if !found {
+			t.Fatalf("replaced id %d not found by its new hash", i%1000)
+		}
+	}
+	if got := idx.Remove(strconv.Itoa(5)); got != 1 {
+		t.Fatalf("Remove after compaction = %d, want 1", got)
+	}
+}
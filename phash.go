@@ -42,6 +42,21 @@ func gray32x32(img image.Image) [32][32]float64 {
 	return out
 }
 
+// grayMatrix reads a w x h grayscale image into a row-major [h][w] matrix of 0..255 values.
+func grayMatrix(img image.Image, w, h int) [][]float64 {
+	out := make([][]float64, h)
+	b := img.Bounds()
+	for y := 0; y < h; y++ {
+		row := make([]float64, w)
+		for x := 0; x < w; x++ {
+			r, _, _, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			row[x] = float64(r >> 8)
+		}
+		out[y] = row
+	}
+	return out
+}
+
 // Precomputed cosine table for N=32:
 // cos32[k][n] = cos((2*n+1)*k*pi/(2*N)), where k in [0..7], n in [0..31]
 var cos32 = func() [8][32]float64 {
@@ -68,17 +68,17 @@ func TestPHashColorOnlyDifferencesStayClose(t *testing.T) {
 	}
 }
 
-func decodeTestImage(t *testing.T, path string) image.Image {
-	t.Helper()
+func decodeTestImage(tb testing.TB, path string) image.Image {
+	tb.Helper()
 	f, err := os.Open(path)
 	if err != nil {
-		t.Fatalf("open %s: %v", path, err)
+		tb.Fatalf("open %s: %v", path, err)
 	}
 	defer f.Close()
 
 	decoded, _, err := DecodeAny(f)
 	if err != nil {
-		t.Fatalf("decode %s: %v", path, err)
+		tb.Fatalf("decode %s: %v", path, err)
 	}
 	return decoded
 }
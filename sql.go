@@ -0,0 +1,54 @@
+package phash
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// Value implements driver.Valuer.
+//
+// 64-bit hashes are stored as int64 (the bits reinterpreted as a signed integer),
+// which fits a bigint column and round-trips hashes with the top bit set.
+// Wider hashes are stored as their String form. The zero Hash is stored as NULL.
+// To store a 64-bit hash in a text column, pass h.String() or h.Hex() instead.
+func (h Hash) Value() (driver.Value, error) {
+	switch {
+	case h.IsZero():
+		return nil, nil
+	case len(h.words) == 1:
+		return int64(h.words[0]), nil
+	default:
+		return h.String(), nil
+	}
+}
+
+// Scan implements sql.Scanner.
+//
+// Integer columns are read as 64-bit hashes. The algorithm is kept when the
+// receiver already has one (scan into a Hash prepared with NewHash), and
+// defaults to pHash otherwise. Text columns are parsed with ParseHash, so both
+// "algo:hex" and bare hex work. NULL yields the zero Hash.
+func (h *Hash) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*h = Hash{}
+		return nil
+	case int64:
+		algo := h.algo
+		if algo == "" {
+			algo = AlgorithmPHash
+		}
+		parsed, err := NewHash(algo, uint64(v))
+		if err != nil {
+			return err
+		}
+		*h = parsed
+		return nil
+	case string:
+		return h.UnmarshalText([]byte(v))
+	case []byte:
+		return h.UnmarshalText(v)
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidHash, src)
+	}
+}
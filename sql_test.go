@@ -0,0 +1,60 @@
+package phash
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestHashSQLRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name  string
+		hash  Hash
+		value driver.Value
+	}{
+		{name: "top bit set", hash: mustNewHash(t, AlgorithmPHash, 0xfa85955a872769cb), value: int64(-0x57a6aa578d89635)},
+		{name: "small", hash: mustNewHash(t, AlgorithmPHash, 42), value: int64(42)},
+		{name: "wide", hash: mustNewHash(t, AlgorithmDHash128, 1, 2), value: "dhash128:00000000000000010000000000000002"},
+		{name: "null", hash: Hash{}, value: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := tc.hash.Value()
+			if err != nil {
+				t.Fatalf("Value: %v", err)
+			}
+			if v != tc.value {
+				t.Fatalf("Value() = %#v, want %#v", v, tc.value)
+			}
+			var scanned Hash
+			if err := scanned.Scan(v); err != nil {
+				t.Fatalf("Scan(%#v): %v", v, err)
+			}
+			if !scanned.Equal(tc.hash) {
+				t.Fatalf("Scan(%#v) = %v, want %v", v, scanned, tc.hash)
+			}
+		})
+	}
+}
+
+func TestHashScanText(t *testing.T) {
+	want := mustNewHash(t, AlgorithmPHash, 0xfa85955a872769cb)
+	for _, src := range []any{"fa85955a872769cb", []byte("phash:fa85955a872769cb")} {
+		var h Hash
+		if err := h.Scan(src); err != nil {
+			t.Fatalf("Scan(%v): %v", src, err)
+		}
+		if !h.Equal(want) {
+			t.Fatalf("Scan(%v) = %v, want %v", src, h, want)
+		}
+	}
+}
+
+func TestHashScanKeepsAlgorithm(t *testing.T) {
+	h := mustNewHash(t, AlgorithmDHash, 0)
+	if err := h.Scan(int64(-1)); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if got, want := h.String(), "dhash:ffffffffffffffff"; got != want {
+		t.Fatalf("Scan into dHash = %q, want %q", got, want)
+	}
+}
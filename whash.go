@@ -0,0 +1,80 @@
+package phash
+
+import "image"
+
+// whashMaxScale caps the working resolution of WHash to bound its cost on large images.
+const whashMaxScale = 256
+
+// WHash computes a 64-bit wavelet hash (wHash) using a Haar transform.
+//
+// Pipeline (mirrors ImageHash's whash with mode="haar"):
+//  1. Grayscale
+//  2. Resize to SxS, S = largest power of two <= min(width, height), clamped to [8, 256]
+//  3. Remove the lowest-frequency Haar band (the image mean)
+//  4. Multi-level 2D Haar decomposition down to an 8x8 low-frequency (LL) band
+//  5. Median of the 64 LL coefficients
+//  6. Build 64-bit hash: bit=1 if coeff>median, row-major, MSB-first
+func WHash(image image.Image) uint64 {
+	if image == nil {
+		return 0
+	}
+	gray := Grayscale(image)
+	b := gray.Bounds()
+	scale := whashScale(min(b.Dx(), b.Dy()))
+	pix := grayMatrix(Resize(gray, uint32(scale), uint32(scale)), scale, scale)
+
+	// Normalize to 0..1 and drop the max-level LL coefficient. A full Haar
+	// decomposition with the top LL band zeroed reconstructs to x - mean(x).
+	var sum float64
+	for y := range pix {
+		for x := range pix[y] {
+			pix[y][x] /= 255
+			sum += pix[y][x]
+		}
+	}
+	mean := sum / float64(scale*scale)
+	for y := range pix {
+		for x := range pix[y] {
+			pix[y][x] -= mean
+		}
+	}
+
+	ll := pix
+	for len(ll) > 8 {
+		ll = haarLL(ll)
+	}
+
+	v := make([]float64, 0, 64)
+	var c [8][8]float64
+	for y := 0; y < 8; y++ {
+		copy(c[y][:], ll[y])
+		v = append(v, ll[y]...)
+	}
+	return hashFromPixels(c, medianFloat64(v))
+}
+
+// whashScale returns the largest power of two <= side, clamped to [8, whashMaxScale].
+func whashScale(side int) int {
+	scale := 8
+	for scale*2 <= side && scale*2 <= whashMaxScale {
+		scale *= 2
+	}
+	return scale
+}
+
+// haarLL performs one level of the orthonormal 2D Haar transform and returns
+// the low-frequency (LL) band, which has half the width and height of m.
+// m must be square with an even side.
+func haarLL(m [][]float64) [][]float64 {
+	n := len(m) / 2
+	out := make([][]float64, n)
+	for y := 0; y < n; y++ {
+		row := make([]float64, n)
+		r0, r1 := m[2*y], m[2*y+1]
+		for x := 0; x < n; x++ {
+			row[x] = (r0[2*x] + r0[2*x+1] + r1[2*x] + r1[2*x+1]) / 2
+		}
+		out[y] = row
+	}
+	return out
+}
@@ -0,0 +1,34 @@
+package phash
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWHashSweaterVariantsMatchExpected(t *testing.T) {
+	const expected uint64 = 0xff9981c3818181ff
+	for _, name := range []string{"sweater-thumb.jpg", "sweater-medium.jpg", "sweater-large.jpg"} {
+		path := filepath.Join("test_data", name)
+		t.Run(path, func(t *testing.T) {
+			img := decodeTestImage(t, path)
+			if got := WHash(img); got != expected {
+				t.Fatalf("unexpected wHash for %s: got %016x want %016x", path, got, expected)
+			}
+		})
+	}
+}
+
+func TestWHashScale(t *testing.T) {
+	testCases := []struct{ side, want int }{
+		{side: 1, want: 8},
+		{side: 8, want: 8},
+		{side: 190, want: 128},
+		{side: 256, want: 256},
+		{side: 4000, want: 256},
+	}
+	for _, tc := range testCases {
+		if got := whashScale(tc.side); got != tc.want {
+			t.Fatalf("whashScale(%d) = %d, want %d", tc.side, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package phash
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// WideHash is a multi-word hash for sizes above 64 bits.
+// Bits are packed row-major, MSB-first: word 0 holds the first 64 bits.
+type WideHash []uint64
+
+// Bits returns the hash length in bits.
+func (h WideHash) Bits() int { return len(h) * 64 }
+
+// HammingDistanceWide returns the number of differing bits between two wide hashes.
+// Hashes of different lengths are not comparable; they are reported as
+// completely different (the bit length of the longer hash).
+func HammingDistanceWide(a, b WideHash) int {
+	if len(a) != len(b) {
+		return max(a.Bits(), b.Bits())
+	}
+	d := 0
+	for i := range a {
+		d += bits.OnesCount64(a[i] ^ b[i])
+	}
+	return d
+}
+
+// maxHighFreqFactor bounds highFreqFactor so the resize stays small: ImageHash's
+// default is 4, and 8 already means a 256x256 DCT input at hashSize 32.
+const maxHighFreqFactor = 8
+
+// PHashSize computes a perceptual hash of hashSize*hashSize bits.
+//
+// hashSize must be 8, 16 or 32 (64-, 256- or 1024-bit hashes) and highFreqFactor
+// between 1 and 8, like ImageHash's phash(hash_size, highfreq_factor). The pipeline is the one of PHash
+// with N = hashSize*highFreqFactor:
+//  1. Resize to NxN
+//  2. Grayscale
+//  3. 2D DCT (N), keep top-left hashSize x hashSize coefficients
+//  4. Median of the coefficients excluding the first row and column
+//  5. Build the hash: bit=1 if coeff>median
+//
+// PHashSize(img, 8, 4) yields the same bits as PHash(img).
+func PHashSize(image image.Image, hashSize, highFreqFactor int) (WideHash, error) {
+	if hashSize != 8 && hashSize != 16 && hashSize != 32 {
+		return nil, ErrInvalidHashSize
+	}
+	if highFreqFactor < 1 || highFreqFactor > maxHighFreqFactor {
+		return nil, ErrInvalidHighFreqFactor
+	}
+	h := make(WideHash, hashSize*hashSize/64)
+	if image == nil {
+		return h, nil
+	}
+
+	n := hashSize * highFreqFactor
+	gray := Grayscale(image)
+	resized := Resize(gray, uint32(n), uint32(n))
+	pix := grayMatrix(resized, n, n)
+	coeff := dctTopLeft(pix, hashSize)
+
+	v := make([]float64, 0, (hashSize-1)*(hashSize-1))
+	for y := 1; y < hashSize; y++ {
+		v = append(v, coeff[y][1:]...)
+	}
+	sort.Float64s(v)
+	med := v[len(v)/2]
+
+	for i := 0; i < hashSize*hashSize; i++ {
+		if coeff[i/hashSize][i%hashSize] > med {
+			h[i/64] |= 1 << (63 - uint(i%64))
+		}
+	}
+	return h, nil
+}
+
+// dctTopLeft computes the top-left size x size DCT coefficients from an NxN block of pixel values.
+// It generalizes dctTopLeft8x8 and keeps its summation order, so results are bit-identical for N=32, size=8.
+func dctTopLeft(pix [][]float64, size int) [][]float64 {
+	n := len(pix)
+	fn := float64(n)
+
+	cos := make([][]float64, size)
+	for k := 0; k < size; k++ {
+		cos[k] = make([]float64, n)
+		for i := 0; i < n; i++ {
+			cos[k][i] = math.Cos((2*float64(i) + 1.0) * float64(k) * math.Pi / (2.0 * fn))
+		}
+	}
+
+	c := make([][]float64, size)
+	for v := range c {
+		c[v] = make([]float64, size)
+	}
+	for u := 0; u < size; u++ {
+		au := math.Sqrt(2.0 / fn)
+		if u == 0 {
+			au = math.Sqrt(1.0 / fn)
+		}
+		for v := 0; v < size; v++ {
+			av := math.Sqrt(2.0 / fn)
+			if v == 0 {
+				av = math.Sqrt(1.0 / fn)
+			}
+			var sum float64
+			for y := 0; y < n; y++ {
+				cvy := cos[v][y]
+				for x := 0; x < n; x++ {
+					sum += pix[y][x] * cos[u][x] * cvy
+				}
+			}
+			// [yfreq][xfreq], same as dctTopLeft8x8
+			c[v][u] = au * av * sum
+		}
+	}
+	return c
+}
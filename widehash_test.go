@@ -0,0 +1,66 @@
+package phash
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPHashSize8MatchesPHash(t *testing.T) {
+	for _, name := range []string{"sweater-thumb.jpg", "tblue.jpeg", "kblue.webp"} {
+		path := filepath.Join("test_data", name)
+		t.Run(path, func(t *testing.T) {
+			img := decodeTestImage(t, path)
+			h, err := PHashSize(img, 8, 4)
+			if err != nil {
+				t.Fatalf("PHashSize: %v", err)
+			}
+			if len(h) != 1 || h[0] != PHash(img) {
+				t.Fatalf("PHashSize(8, 4) = %016x, want [%016x]", h, PHash(img))
+			}
+		})
+	}
+}
+
+func TestPHashSize16SweaterVariantsMatchExpected(t *testing.T) {
+	expected := WideHash{0xfa15857e957a5aa1, 0x87f827a16989cb4d, 0xca4f639621864936, 0x9c633c6bda713670}
+	for _, name := range []string{"sweater-thumb.jpg", "sweater-medium.jpg", "sweater-large.jpg"} {
+		path := filepath.Join("test_data", name)
+		t.Run(path, func(t *testing.T) {
+			h, err := PHashSize(decodeTestImage(t, path), 16, 4)
+			if err != nil {
+				t.Fatalf("PHashSize: %v", err)
+			}
+			if h.Bits() != 256 || HammingDistanceWide(h, expected) != 0 {
+				t.Fatalf("unexpected 256-bit pHash: got %016x want %016x", h, expected)
+			}
+		})
+	}
+}
+
+func TestPHashSizeRejectsInvalidParameters(t *testing.T) {
+	if _, err := PHashSize(nil, 12, 4); !errors.Is(err, ErrInvalidHashSize) {
+		t.Fatalf("hash size 12: got %v want %v", err, ErrInvalidHashSize)
+	}
+	if _, err := PHashSize(nil, 16, 0); !errors.Is(err, ErrInvalidHighFreqFactor) {
+		t.Fatalf("factor 0: got %v want %v", err, ErrInvalidHighFreqFactor)
+	}
+	if _, err := PHashSize(nil, 32, maxHighFreqFactor+1); !errors.Is(err, ErrInvalidHighFreqFactor) {
+		t.Fatalf("factor %d: got %v want %v", maxHighFreqFactor+1, err, ErrInvalidHighFreqFactor)
+	}
+	h, err := PHashSize(nil, 32, 4)
+	if err != nil || h.Bits() != 1024 {
+		t.Fatalf("PHashSize(nil, 32, 4) = %d bits, %v; want 1024 zero bits", h.Bits(), err)
+	}
+}
+
+func TestHammingDistanceWide(t *testing.T) {
+	a := WideHash{0xff, 0}
+	b := WideHash{0x0f, 1}
+	if got := HammingDistanceWide(a, b); got != 5 {
+		t.Fatalf("HammingDistanceWide = %d, want 5", got)
+	}
+	if got := HammingDistanceWide(a, WideHash{0}); got != 128 {
+		t.Fatalf("length mismatch: got %d, want 128", got)
+	}
+}